@@ -0,0 +1,161 @@
+// Package apperr defines the errors the API returns to clients. Every error
+// carries an HTTP status and a stable Code that clients can branch on; the
+// message is for humans and may change.
+package apperr
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Code identifies a kind of failure. Codes are part of the API and never change.
+type Code string
+
+// Request errors
+const (
+	CodeBadRequest              Code = "BAD_REQUEST"
+	CodeInvalidBody             Code = "INVALID_BODY"
+	CodeMissingParameter        Code = "MISSING_PARAMETER"
+	CodeInvalidParameter        Code = "INVALID_PARAMETER"
+	CodeValidationFailed        Code = "VALIDATION_FAILED"
+	CodeInvalidCursor           Code = "INVALID_CURSOR"
+	CodeInvalidNotificationType Code = "INVALID_NOTIFICATION_TYPE"
+	CodeBatchTooLarge           Code = "BATCH_TOO_LARGE"
+)
+
+// Lookup errors
+const (
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeMethodNotAllowed     Code = "METHOD_NOT_ALLOWED"
+	CodeUserNotFound         Code = "USER_NOT_FOUND"
+	CodeNotificationNotFound Code = "NOTIFICATION_NOT_FOUND"
+	CodeNotificationNotOwned Code = "NOTIFICATION_NOT_OWNED"
+	CodeTargetNotFound       Code = "TARGET_NOT_FOUND"
+)
+
+// Idempotency errors
+const (
+	CodeIdempotencyKeyInProgress Code = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	CodeIdempotencyKeyMismatch   Code = "IDEMPOTENCY_KEY_MISMATCH"
+)
+
+// Server errors
+const (
+	CodeInternal            Code = "INTERNAL_ERROR"
+	CodeReviewitUnavailable Code = "REVIEWIT_UNAVAILABLE"
+	CodeServiceUnavailable  Code = "SERVICE_UNAVAILABLE"
+)
+
+// Error is an API error. Err holds the underlying cause, which is logged but
+// never sent to clients. Errors lists every failed field of a
+// VALIDATION_FAILED error; Field is the first of them.
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Field   string
+	Errors  []FieldError
+	Err     error
+}
+
+// FieldError describes one request field that failed validation. Rule names
+// the check that failed, e.g. "required" or "max".
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// New creates an error with the given status, code and message
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Newf is New with a formatted message
+func Newf(status int, code Code, format string, args ...interface{}) *Error {
+	return New(status, code, fmt.Sprintf(format, args...))
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithField returns a copy of the error that points at the offending request field
+func (e *Error) WithField(field string) *Error {
+	copy := *e
+	copy.Field = field
+	return &copy
+}
+
+// Wrap returns a copy of the error with the underlying cause attached
+func (e *Error) Wrap(err error) *Error {
+	copy := *e
+	copy.Err = err
+	return &copy
+}
+
+// BadRequest returns a 400 error
+func BadRequest(code Code, message string) *Error {
+	return New(http.StatusBadRequest, code, message)
+}
+
+// NotFound returns a 404 error
+func NotFound(code Code, message string) *Error {
+	return New(http.StatusNotFound, code, message)
+}
+
+// InvalidBody reports a request body that couldn't be decoded
+func InvalidBody(err error) *Error {
+	return BadRequest(CodeInvalidBody, "Request body is invalid: "+err.Error())
+}
+
+// MissingParameter reports a required query parameter that wasn't given
+func MissingParameter(name string) *Error {
+	return BadRequest(CodeMissingParameter, name+" query parameter is required").WithField(name)
+}
+
+// InvalidParameter reports a query parameter with a bad value
+func InvalidParameter(name string, message string) *Error {
+	return BadRequest(CodeInvalidParameter, message).WithField(name)
+}
+
+// Invalid reports a request body field that failed a check of its own
+func Invalid(field string, message string) *Error {
+	return ValidationFailed([]FieldError{{Field: field, Rule: "invalid", Message: message}})
+}
+
+// ValidationFailed reports every request body field that failed validation
+func ValidationFailed(errs []FieldError) *Error {
+	message := errs[0].Message
+	if len(errs) > 1 {
+		message = fmt.Sprintf("%d fields are invalid", len(errs))
+	}
+	e := BadRequest(CodeValidationFailed, message).WithField(errs[0].Field)
+	e.Errors = errs
+	return e
+}
+
+// UserNotFound reports a user referenced by the request that doesn't exist.
+// It is a 400 because the request, not the URL, names the user.
+func UserNotFound(field string, userID string) *Error {
+	return Newf(http.StatusBadRequest, CodeUserNotFound,
+		"User %s does not exist. Please ensure the user is created in the notification service first.", userID).WithField(field)
+}
+
+// NotificationNotFound reports a notification that doesn't exist or isn't visible to the user
+func NotificationNotFound() *Error {
+	return NotFound(CodeNotificationNotFound, "Notification not found")
+}
+
+// Internal reports an unexpected failure; err is logged, message is sent
+func Internal(message string, err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
@@ -0,0 +1,870 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+// systemReadState is one user's read/dismiss record for a system notification
+type systemReadState struct {
+	ReadAt      *time.Time
+	DismissedAt *time.Time
+}
+
+// systemReadKey identifies a systemReadState by notification and user
+type systemReadKey struct {
+	NotificationID string
+	UserID         string
+}
+
+// archivedNotification is a notification removed by the retention worker in archive mode
+type archivedNotification struct {
+	Kind       string
+	ID         string
+	UserID     string
+	Payload    []byte
+	CreatedAt  time.Time
+	ArchivedAt time.Time
+}
+
+// idempotencyKey identifies an idempotencyEntry by key and scope
+type idempotencyKey struct {
+	Key   string
+	Scope string
+}
+
+// idempotencyEntry is a stored idempotent response and when it expires
+type idempotencyEntry struct {
+	Response  IdempotentResponse
+	ExpiresAt time.Time
+}
+
+// notificationEntry is the part of a notification that filters and retention rules look at
+type notificationEntry struct {
+	ID        string
+	UserID    string
+	CreatedAt time.Time
+	Read      bool
+	ProductID string
+	FromID    string
+}
+
+// matches reports whether a notification of the given kind passes the query's filters
+func (e notificationEntry) matches(q NotificationQuery, kind string) bool {
+	if !q.IncludesKind(kind) {
+		return false
+	}
+	if q.Read != nil && e.Read != *q.Read {
+		return false
+	}
+	if q.ProductID != "" && e.ProductID != q.ProductID {
+		return false
+	}
+	if q.FromID != "" && e.FromID != q.FromID {
+		return false
+	}
+	if q.Since != nil && e.CreatedAt.Before(*q.Since) {
+		return false
+	}
+	if q.Until != nil && !e.CreatedAt.Before(*q.Until) {
+		return false
+	}
+	if q.After != nil && q.After.Includes(e.CreatedAt, kind, e.ID) {
+		return false
+	}
+	return true
+}
+
+// MemoryStore is an in-process Store for tests and local development.
+// Nothing survives a restart.
+type MemoryStore struct {
+	mutex       sync.RWMutex
+	users       map[string]models.User
+	user        []models.UserNotification
+	owner       []models.ProductOwnerNotification
+	like        []models.LikeNotification
+	system      []models.SystemNotification
+	systemReads map[systemReadKey]*systemReadState
+	archive     map[string]archivedNotification // kind + "/" + id
+	idempotency map[idempotencyKey]*idempotencyEntry
+	now         func() time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:       make(map[string]models.User),
+		systemReads: make(map[systemReadKey]*systemReadState),
+		archive:     make(map[string]archivedNotification),
+		idempotency: make(map[idempotencyKey]*idempotencyEntry),
+		now:         func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// newUUID returns a random version 4 UUID, matching gen_random_uuid()
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// UpsertUser creates the user or refreshes its non-empty fields
+func (s *MemoryStore) UpsertUser(user *models.User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existing, ok := s.users[user.ID]; ok {
+		if user.Username == "" {
+			user.Username = existing.Username
+		}
+		if user.FullName == "" {
+			user.FullName = existing.FullName
+		}
+	}
+	s.users[user.ID] = *user
+	return nil
+}
+
+// UserExists reports whether a user with the given ID exists
+func (s *MemoryStore) UserExists(id string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, ok := s.users[id]
+	return ok, nil
+}
+
+// CreateUserNotification stores a comment/reply notification
+func (s *MemoryStore) CreateUserNotification(n *models.UserNotification) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.createUser(n)
+}
+
+// CreateOwnerNotification stores a product owner notification
+func (s *MemoryStore) CreateOwnerNotification(n *models.ProductOwnerNotification) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.createOwner(n)
+}
+
+// CreateLikeNotification stores a like notification with a generated ID
+func (s *MemoryStore) CreateLikeNotification(n *models.LikeNotification) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.createLike(n)
+}
+
+// CreateSystemNotification stores a system notification and its recipients
+func (s *MemoryStore) CreateSystemNotification(n *models.SystemNotification) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.createSystem(n)
+}
+
+// CreateNotifications stores the batch, undoing the items already added when
+// one of them fails
+func (s *MemoryStore) CreateNotifications(batch []NewNotification) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	users, owners, likes, systems := len(s.user), len(s.owner), len(s.like), len(s.system)
+	for i, item := range batch {
+		var err error
+		switch item.Kind {
+		case models.KindUser:
+			err = s.createUser(item.User)
+		case models.KindOwner:
+			err = s.createOwner(item.Owner)
+		case models.KindLike:
+			err = s.createLike(item.Like)
+		case models.KindSystem:
+			err = s.createSystem(item.System)
+		default:
+			err = fmt.Errorf("unknown notification kind %q", item.Kind)
+		}
+		if err != nil {
+			s.user, s.owner, s.like, s.system = s.user[:users], s.owner[:owners], s.like[:likes], s.system[:systems]
+			return fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// requireUsers returns an error for the first ID that isn't a user, as the
+// foreign keys do in Postgres
+func (s *MemoryStore) requireUsers(ids ...string) error {
+	for _, id := range ids {
+		if _, ok := s.users[id]; !ok {
+			return fmt.Errorf("user %q does not exist", id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) createUser(n *models.UserNotification) error {
+	if err := s.requireUsers(n.ParentUserID); err != nil {
+		return err
+	}
+	for _, existing := range s.user {
+		if existing.ID == n.ID {
+			return fmt.Errorf("user notification %q already exists", n.ID)
+		}
+	}
+	n.CreatedAt = s.now()
+	s.user = append(s.user, *n)
+	return nil
+}
+
+func (s *MemoryStore) createOwner(n *models.ProductOwnerNotification) error {
+	if err := s.requireUsers(n.OwnerID); err != nil {
+		return err
+	}
+	for _, existing := range s.owner {
+		if existing.ID == n.ID {
+			return fmt.Errorf("owner notification %q already exists", n.ID)
+		}
+	}
+	n.CreatedAt = s.now()
+	s.owner = append(s.owner, *n)
+	return nil
+}
+
+func (s *MemoryStore) createLike(n *models.LikeNotification) error {
+	if err := s.requireUsers(n.TargetUserID, n.FromID); err != nil {
+		return err
+	}
+	n.ID = newUUID()
+	n.CreatedAt = s.now()
+	s.like = append(s.like, *n)
+	return nil
+}
+
+func (s *MemoryStore) createSystem(n *models.SystemNotification) error {
+	if err := s.requireUsers(n.TargetUserIDs...); err != nil {
+		return err
+	}
+	for _, existing := range s.system {
+		if existing.ID == n.ID {
+			return fmt.Errorf("system notification %q already exists", n.ID)
+		}
+	}
+	n.CreatedAt = s.now()
+	stored := *n
+	stored.TargetUserIDs = append(stored.TargetUserIDs[:0:0], n.TargetUserIDs...)
+	sort.Strings(stored.TargetUserIDs)
+	s.system = append(s.system, stored)
+	return nil
+}
+
+// systemVisible reports whether a system notification is broadcast or targets the user
+func systemVisible(n models.SystemNotification, userID string) bool {
+	if len(n.TargetUserIDs) == 0 {
+		return true
+	}
+	for _, id := range n.TargetUserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func userEntry(n models.UserNotification) notificationEntry {
+	return notificationEntry{n.ID, n.ParentUserID, n.CreatedAt, n.Read, n.ProductID, n.FromID}
+}
+
+func ownerEntry(n models.ProductOwnerNotification) notificationEntry {
+	return notificationEntry{n.ID, n.OwnerID, n.CreatedAt, n.Read, n.ProductID, n.FromID}
+}
+
+func likeEntry(n models.LikeNotification) notificationEntry {
+	return notificationEntry{n.ID, n.TargetUserID, n.CreatedAt, n.Read, n.ProductID, n.FromID}
+}
+
+// ListNotifications returns the user's notifications matching the query, newest first
+func (s *MemoryStore) ListNotifications(userID string, q NotificationQuery) (*NotificationSet, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	set := &NotificationSet{}
+	for _, n := range s.user {
+		if n.ParentUserID == userID && userEntry(n).matches(q, models.KindUser) {
+			set.User = append(set.User, n)
+		}
+	}
+	for _, n := range s.owner {
+		if n.OwnerID == userID && ownerEntry(n).matches(q, models.KindOwner) {
+			set.Owner = append(set.Owner, n)
+		}
+	}
+	for _, n := range s.like {
+		if n.TargetUserID == userID && likeEntry(n).matches(q, models.KindLike) {
+			set.Like = append(set.Like, n)
+		}
+	}
+	for _, n := range s.system {
+		var ok bool
+		if n.Read, ok = s.systemReadFor(n, userID); !ok {
+			continue
+		}
+		if (notificationEntry{ID: n.ID, UserID: userID, CreatedAt: n.CreatedAt, Read: n.Read}).matches(q, models.KindSystem) {
+			set.System = append(set.System, n)
+		}
+	}
+
+	set.sortNewestFirst()
+	set.page(q.Limit)
+	return set, nil
+}
+
+// CountUnread returns the user's unread totals per kind
+func (s *MemoryStore) CountUnread(userID string) (*models.NotificationCounts, error) {
+	unread, err := s.ListNotifications(userID, Unread())
+	if err != nil {
+		return nil, err
+	}
+	counts := &models.NotificationCounts{
+		User:   len(unread.User),
+		Owner:  len(unread.Owner),
+		Like:   len(unread.Like),
+		System: len(unread.System),
+	}
+	counts.Sum()
+	return counts, nil
+}
+
+// MarkRead marks a notification as read and returns the user it belongs to
+func (s *MemoryStore) MarkRead(kind string, id string, userID string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch kind {
+	case models.KindUser:
+		for i := range s.user {
+			if s.user[i].ID == id {
+				s.user[i].Read = true
+				return s.user[i].ParentUserID, nil
+			}
+		}
+	case models.KindOwner:
+		for i := range s.owner {
+			if s.owner[i].ID == id {
+				s.owner[i].Read = true
+				return s.owner[i].OwnerID, nil
+			}
+		}
+	case models.KindLike:
+		for i := range s.like {
+			if s.like[i].ID == id {
+				s.like[i].Read = true
+				return s.like[i].TargetUserID, nil
+			}
+		}
+	case models.KindSystem:
+		if _, ok := s.users[userID]; !ok {
+			return "", ErrNotFound
+		}
+		for _, n := range s.system {
+			if n.ID == id && systemVisible(n, userID) {
+				key := systemReadKey{id, userID}
+				state := s.systemReads[key]
+				if state == nil {
+					state = &systemReadState{}
+					s.systemReads[key] = state
+				}
+				if state.ReadAt == nil {
+					now := s.now()
+					state.ReadAt = &now
+				}
+				return userID, nil
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown notification kind %q", kind)
+	}
+	return "", ErrNotFound
+}
+
+// systemReadFor returns the user's read state for a visible system notification,
+// or false when the user can't see it or has dismissed it
+func (s *MemoryStore) systemReadFor(n models.SystemNotification, userID string) (read bool, ok bool) {
+	if !systemVisible(n, userID) {
+		return false, false
+	}
+	state := s.systemReads[systemReadKey{n.ID, userID}]
+	if state != nil && state.DismissedAt != nil {
+		return false, false
+	}
+	return state != nil && state.ReadAt != nil, true
+}
+
+// FindNotification looks a notification up by ID in each kind in turn
+func (s *MemoryStore) FindNotification(id string, userID string) (*models.InboxItem, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, n := range s.user {
+		if n.ID == id && (userID == "" || n.ParentUserID == userID) {
+			item := models.InboxItemFromUser(n)
+			return &item, nil
+		}
+	}
+	for _, n := range s.owner {
+		if n.ID == id && (userID == "" || n.OwnerID == userID) {
+			item := models.InboxItemFromOwner(n)
+			return &item, nil
+		}
+	}
+	for _, n := range s.like {
+		if n.ID == id && (userID == "" || n.TargetUserID == userID) {
+			item := models.InboxItemFromLike(n)
+			return &item, nil
+		}
+	}
+	for _, n := range s.system {
+		if n.ID != id {
+			continue
+		}
+		if userID != "" {
+			read, ok := s.systemReadFor(n, userID)
+			if !ok {
+				continue
+			}
+			n.Read = read
+		}
+		item := models.InboxItemFromSystem(n)
+		return &item, nil
+	}
+	return nil, ErrNotFound
+}
+
+// MarkAllRead marks the user's matching unread notifications as read
+func (s *MemoryStore) MarkAllRead(userID string, q NotificationQuery) (*models.NotificationCounts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	unread := false
+	q.Read = &unread
+	q.After = nil
+	counts := &models.NotificationCounts{}
+
+	for i := range s.user {
+		if s.user[i].ParentUserID == userID && userEntry(s.user[i]).matches(q, models.KindUser) {
+			s.user[i].Read = true
+			counts.User++
+		}
+	}
+	for i := range s.owner {
+		if s.owner[i].OwnerID == userID && ownerEntry(s.owner[i]).matches(q, models.KindOwner) {
+			s.owner[i].Read = true
+			counts.Owner++
+		}
+	}
+	for i := range s.like {
+		if s.like[i].TargetUserID == userID && likeEntry(s.like[i]).matches(q, models.KindLike) {
+			s.like[i].Read = true
+			counts.Like++
+		}
+	}
+	if _, ok := s.users[userID]; ok {
+		for _, n := range s.system {
+			if !systemVisible(n, userID) {
+				continue
+			}
+			key := systemReadKey{n.ID, userID}
+			state := s.systemReads[key]
+			if state != nil && (state.ReadAt != nil || state.DismissedAt != nil) {
+				continue
+			}
+			if !(notificationEntry{ID: n.ID, CreatedAt: n.CreatedAt}).matches(q, models.KindSystem) {
+				continue
+			}
+			now := s.now()
+			s.systemReads[key] = &systemReadState{ReadAt: &now}
+			counts.System++
+		}
+	}
+
+	counts.Sum()
+	return counts, nil
+}
+
+// MarkReadByIDs marks the user's notifications with the given IDs as read
+func (s *MemoryStore) MarkReadByIDs(userID string, ids []string) ([]ReadResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	found := make(map[string]ReadResult)
+	mark := func(id string, kind string, read *bool) {
+		if !wanted[id] {
+			return
+		}
+		if _, ok := found[id]; ok {
+			return
+		}
+		status := ReadAlreadyRead
+		if !*read {
+			*read = true
+			status = ReadUpdated
+		}
+		found[id] = ReadResult{ID: id, Kind: kind, Status: status}
+	}
+
+	for i := range s.user {
+		if s.user[i].ParentUserID == userID {
+			mark(s.user[i].ID, models.KindUser, &s.user[i].Read)
+		}
+	}
+	for i := range s.owner {
+		if s.owner[i].OwnerID == userID {
+			mark(s.owner[i].ID, models.KindOwner, &s.owner[i].Read)
+		}
+	}
+	for i := range s.like {
+		if s.like[i].TargetUserID == userID {
+			mark(s.like[i].ID, models.KindLike, &s.like[i].Read)
+		}
+	}
+	if _, ok := s.users[userID]; ok {
+		for _, n := range s.system {
+			read, ok := s.systemReadFor(n, userID)
+			if !ok {
+				continue
+			}
+			mark(n.ID, models.KindSystem, &read)
+			if read {
+				key := systemReadKey{n.ID, userID}
+				if state := s.systemReads[key]; state == nil || state.ReadAt == nil {
+					now := s.now()
+					s.systemReads[key] = &systemReadState{ReadAt: &now}
+				}
+			}
+		}
+	}
+
+	return readResults(ids, found), nil
+}
+
+// DeleteNotification deletes the user's notification by ID, or dismisses a
+// system notification for them
+func (s *MemoryStore) DeleteNotification(id string, userID string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, n := range s.user {
+		if n.ID == id && n.ParentUserID == userID {
+			s.user = append(s.user[:i], s.user[i+1:]...)
+			return models.KindUser, nil
+		}
+	}
+	for i, n := range s.owner {
+		if n.ID == id && n.OwnerID == userID {
+			s.owner = append(s.owner[:i], s.owner[i+1:]...)
+			return models.KindOwner, nil
+		}
+	}
+	for i, n := range s.like {
+		if n.ID == id && n.TargetUserID == userID {
+			s.like = append(s.like[:i], s.like[i+1:]...)
+			return models.KindLike, nil
+		}
+	}
+	if _, ok := s.users[userID]; ok {
+		for _, n := range s.system {
+			if _, ok := s.systemReadFor(n, userID); ok && n.ID == id {
+				s.dismissSystem(n.ID, userID)
+				return models.KindSystem, nil
+			}
+		}
+	}
+	return "", ErrNotFound
+}
+
+// dismissSystem records that the user dismissed a system notification
+func (s *MemoryStore) dismissSystem(id string, userID string) {
+	key := systemReadKey{id, userID}
+	state := s.systemReads[key]
+	if state == nil {
+		state = &systemReadState{}
+		s.systemReads[key] = state
+	}
+	now := s.now()
+	state.DismissedAt = &now
+}
+
+// DeleteNotifications deletes the user's matching notifications and dismisses
+// the matching system notifications
+func (s *MemoryStore) DeleteNotifications(userID string, q NotificationQuery) (*NotificationSet, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	q.After = nil
+	set := &NotificationSet{}
+
+	s.user, set.User = partition(s.user, func(n models.UserNotification) bool {
+		return n.ParentUserID == userID && userEntry(n).matches(q, models.KindUser)
+	})
+	s.owner, set.Owner = partition(s.owner, func(n models.ProductOwnerNotification) bool {
+		return n.OwnerID == userID && ownerEntry(n).matches(q, models.KindOwner)
+	})
+	s.like, set.Like = partition(s.like, func(n models.LikeNotification) bool {
+		return n.TargetUserID == userID && likeEntry(n).matches(q, models.KindLike)
+	})
+	if _, ok := s.users[userID]; ok {
+		for _, n := range s.system {
+			var ok bool
+			if n.Read, ok = s.systemReadFor(n, userID); !ok {
+				continue
+			}
+			if (notificationEntry{ID: n.ID, UserID: userID, CreatedAt: n.CreatedAt, Read: n.Read}).matches(q, models.KindSystem) {
+				s.dismissSystem(n.ID, userID)
+				set.System = append(set.System, n)
+			}
+		}
+	}
+
+	set.sortNewestFirst()
+	return set, nil
+}
+
+// ReserveIdempotencyKey reserves the key unless a live entry already holds it
+func (s *MemoryStore) ReserveIdempotencyKey(key string, scope string, requestHash string, ttl time.Duration) (*IdempotentResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := idempotencyKey{key, scope}
+	now := s.now()
+	if entry, ok := s.idempotency[id]; ok && now.Before(entry.ExpiresAt) {
+		response := entry.Response
+		return &response, nil
+	}
+	s.idempotency[id] = &idempotencyEntry{
+		Response:  IdempotentResponse{RequestHash: requestHash},
+		ExpiresAt: now.Add(ttl),
+	}
+	return nil, nil
+}
+
+// CompleteIdempotencyKey stores the response for a reserved key
+func (s *MemoryStore) CompleteIdempotencyKey(key string, scope string, response IdempotentResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, ok := s.idempotency[idempotencyKey{key, scope}]; ok {
+		response.RequestHash = entry.Response.RequestHash
+		response.Body = append([]byte(nil), response.Body...)
+		response.Completed = true
+		entry.Response = response
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey drops a pending reservation
+func (s *MemoryStore) ReleaseIdempotencyKey(key string, scope string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := idempotencyKey{key, scope}
+	if entry, ok := s.idempotency[id]; ok && !entry.Response.Completed {
+		delete(s.idempotency, id)
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys removes expired idempotency keys
+func (s *MemoryStore) PurgeIdempotencyKeys(dryRun bool) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	var count int64
+	for id, entry := range s.idempotency {
+		if now.Before(entry.ExpiresAt) {
+			continue
+		}
+		count++
+		if !dryRun {
+			delete(s.idempotency, id)
+		}
+	}
+	return count, nil
+}
+
+// PurgeNotifications applies a retention policy to the in-memory notifications
+func (s *MemoryStore) PurgeNotifications(policy RetentionPolicy, dryRun bool) (*RetentionResult, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := &RetentionResult{Kind: policy.Kind}
+	switch policy.Kind {
+	case models.KindUser:
+		s.user = applyRetention(s, policy, dryRun, result, s.user, func(n models.UserNotification) notificationEntry {
+			return userEntry(n)
+		})
+	case models.KindOwner:
+		s.owner = applyRetention(s, policy, dryRun, result, s.owner, func(n models.ProductOwnerNotification) notificationEntry {
+			return ownerEntry(n)
+		})
+	case models.KindLike:
+		s.like = applyRetention(s, policy, dryRun, result, s.like, func(n models.LikeNotification) notificationEntry {
+			return likeEntry(n)
+		})
+	case models.KindSystem:
+		// Read state is per user, so expiring a read hides it from that user only
+		if policy.ReadBefore != nil {
+			for _, state := range s.systemReads {
+				if state.ReadAt != nil && state.DismissedAt == nil && state.ReadAt.Before(*policy.ReadBefore) {
+					result.ReadExpired++
+					if !dryRun {
+						now := s.now()
+						state.DismissedAt = &now
+					}
+				}
+			}
+		}
+		// Per-user limits don't apply to shared rows, so only the unread cutoff is used
+		systemPolicy := RetentionPolicy{Kind: policy.Kind, UnreadBefore: policy.UnreadBefore, Archive: policy.Archive}
+		kept := applyRetention(s, systemPolicy, dryRun, result, s.system, func(n models.SystemNotification) notificationEntry {
+			return notificationEntry{ID: n.ID, CreatedAt: n.CreatedAt}
+		})
+		if !dryRun {
+			s.system = kept
+			s.dropOrphanedSystemReads()
+		}
+	default:
+		return nil, fmt.Errorf("unknown notification kind %q", policy.Kind)
+	}
+	return result, nil
+}
+
+// applyRetention returns the notifications left after applying the policy and
+// records the counts in result. In dry-run mode the input is returned unchanged.
+func applyRetention[T any](s *MemoryStore, policy RetentionPolicy, dryRun bool, result *RetentionResult, items []T, entry func(T) notificationEntry) []T {
+	remaining := items
+	var removed []T
+
+	if policy.ReadBefore != nil {
+		var gone []T
+		remaining, gone = partition(remaining, func(n T) bool {
+			e := entry(n)
+			return e.Read && e.CreatedAt.Before(*policy.ReadBefore)
+		})
+		result.ReadExpired = int64(len(gone))
+		removed = append(removed, gone...)
+	}
+
+	if policy.UnreadBefore != nil {
+		var gone []T
+		remaining, gone = partition(remaining, func(n T) bool {
+			e := entry(n)
+			return !e.Read && e.CreatedAt.Before(*policy.UnreadBefore)
+		})
+		result.UnreadExpired = int64(len(gone))
+		removed = append(removed, gone...)
+	}
+
+	if policy.MaxPerUser > 0 {
+		// Rank each user's notifications newest first and drop everything past the limit
+		ranked := append([]T(nil), remaining...)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			a, b := entry(ranked[i]), entry(ranked[j])
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.After(b.CreatedAt)
+			}
+			return a.ID > b.ID
+		})
+		perUser := make(map[string]int)
+		overLimit := make(map[string]bool)
+		for _, n := range ranked {
+			e := entry(n)
+			perUser[e.UserID]++
+			if perUser[e.UserID] > policy.MaxPerUser {
+				overLimit[e.ID] = true
+			}
+		}
+
+		var gone []T
+		remaining, gone = partition(remaining, func(n T) bool {
+			return overLimit[entry(n).ID]
+		})
+		result.OverLimit = int64(len(gone))
+		removed = append(removed, gone...)
+	}
+
+	if dryRun {
+		return items
+	}
+
+	if policy.Archive {
+		for _, n := range removed {
+			e := entry(n)
+			payload, _ := json.Marshal(n)
+			s.archive[policy.Kind+"/"+e.ID] = archivedNotification{
+				Kind:       policy.Kind,
+				ID:         e.ID,
+				UserID:     e.UserID,
+				Payload:    payload,
+				CreatedAt:  e.CreatedAt,
+				ArchivedAt: s.now(),
+			}
+		}
+	}
+	return remaining
+}
+
+// partition splits items into those to keep and those drop selects
+func partition[T any](items []T, drop func(T) bool) (kept []T, dropped []T) {
+	kept = make([]T, 0, len(items))
+	for _, n := range items {
+		if drop(n) {
+			dropped = append(dropped, n)
+		} else {
+			kept = append(kept, n)
+		}
+	}
+	return kept, dropped
+}
+
+// dropOrphanedSystemReads mirrors ON DELETE CASCADE for removed system notifications
+func (s *MemoryStore) dropOrphanedSystemReads() {
+	live := make(map[string]bool, len(s.system))
+	for _, n := range s.system {
+		live[n.ID] = true
+	}
+	for key := range s.systemReads {
+		if !live[key.NotificationID] {
+			delete(s.systemReads, key)
+		}
+	}
+}
+
+// sortNewestFirst orders every kind by created_at then id descending, like the SQL queries
+func (set *NotificationSet) sortNewestFirst() {
+	sort.Slice(set.User, func(i, j int) bool {
+		return newer(feedEntry{CreatedAt: set.User[i].CreatedAt, ID: set.User[i].ID}, feedEntry{CreatedAt: set.User[j].CreatedAt, ID: set.User[j].ID})
+	})
+	sort.Slice(set.Owner, func(i, j int) bool {
+		return newer(feedEntry{CreatedAt: set.Owner[i].CreatedAt, ID: set.Owner[i].ID}, feedEntry{CreatedAt: set.Owner[j].CreatedAt, ID: set.Owner[j].ID})
+	})
+	sort.Slice(set.Like, func(i, j int) bool {
+		return newer(feedEntry{CreatedAt: set.Like[i].CreatedAt, ID: set.Like[i].ID}, feedEntry{CreatedAt: set.Like[j].CreatedAt, ID: set.Like[j].ID})
+	})
+	sort.Slice(set.System, func(i, j int) bool {
+		return newer(feedEntry{CreatedAt: set.System[i].CreatedAt, ID: set.System[i].ID}, feedEntry{CreatedAt: set.System[j].CreatedAt, ID: set.System[j].ID})
+	})
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+func TestMemoryStoreRequiresUsers(t *testing.T) {
+	store := NewMemoryStore()
+
+	if err := store.CreateUserNotification(&models.UserNotification{ID: "n1", ParentUserID: "nobody", FromID: "nobody"}); err == nil {
+		t.Error("user notification for an unknown user was stored")
+	}
+	if err := store.CreateOwnerNotification(&models.ProductOwnerNotification{ID: "n2", OwnerID: "nobody"}); err == nil {
+		t.Error("owner notification for an unknown user was stored")
+	}
+
+	store.UpsertUser(&models.User{ID: "owner"})
+	if err := store.CreateOwnerNotification(&models.ProductOwnerNotification{ID: "n2", OwnerID: "owner"}); err != nil {
+		t.Errorf("owner notification for a known user: %v", err)
+	}
+}
+
+func TestMemoryMarkAllReadUnknownUser(t *testing.T) {
+	store := NewMemoryStore()
+	if err := store.CreateSystemNotification(&models.SystemNotification{ID: "s1", Title: "t", Message: "m"}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := store.MarkAllRead("no_such_user", NotificationQuery{})
+	if err != nil {
+		t.Fatalf("MarkAllRead for an unknown user: %v", err)
+	}
+	if counts.Total != 0 {
+		t.Errorf("MarkAllRead for an unknown user marked %+v, want nothing", counts)
+	}
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock key held while migrating so
+// that several instances booting at once don't apply the same migration twice
+const migrationLockID = 727274
+
+// Migration is a single numbered schema change with its up and down SQL
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes whether a migration has been applied
+type MigrationStatus struct {
+	Version   int        `db:"version" json:"version"`
+	Name      string     `db:"name" json:"name"`
+	AppliedAt *time.Time `db:"applied_at" json:"applied_at"`
+}
+
+// LoadMigrations reads the embedded migrations/NNNN_name.{up,down}.sql files
+// and returns them ordered by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionPart, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name.%s.sql", fileName, direction)
+		}
+		version, err := strconv.Atoi(versionPart)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", fileName, err)
+		}
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration %04d has conflicting names %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrate applies every migration that hasn't been recorded in schema_migrations yet
+func Migrate(db *sqlx.DB) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(db, func() error {
+		applied, err := appliedVersions(db)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if applied[m.Version] {
+				continue
+			}
+			log.Printf("Applying migration %04d_%s", m.Version, m.Name)
+			if err := runMigration(db, m.Up, func(tx *sqlx.Tx) error {
+				_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown rolls back the most recently applied migrations, newest first
+func MigrateDown(db *sqlx.DB, steps int) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	return withMigrationLock(db, func() error {
+		applied, err := appliedVersions(db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if !applied[m.Version] {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %04d_%s has no down file", m.Version, m.Name)
+			}
+			log.Printf("Reverting migration %04d_%s", m.Version, m.Name)
+			if err := runMigration(db, m.Down, func(tx *sqlx.Tx) error {
+				_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// GetMigrationStatus lists every known migration and when it was applied
+func GetMigrationStatus(db *sqlx.DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var rows []MigrationStatus
+	if err := withMigrationLock(db, func() error {
+		return db.Select(&rows, "SELECT version, name, applied_at FROM schema_migrations")
+	}); err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int]*time.Time, len(rows))
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status = append(status, MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			AppliedAt: appliedAt[m.Version],
+		})
+	}
+	return status, nil
+}
+
+func ensureMigrationsTable(db *sqlx.DB) error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`)
+	return err
+}
+
+func appliedVersions(db *sqlx.DB) (map[int]bool, error) {
+	var versions []int
+	if err := db.Select(&versions, "SELECT version FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(versions))
+	for _, v := range versions {
+		applied[v] = true
+	}
+	return applied, nil
+}
+
+// runMigration executes the migration SQL and its bookkeeping in one transaction
+func runMigration(db *sqlx.DB, sql string, record func(tx *sqlx.Tx) error) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(sql); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// withMigrationLock runs fn while holding a session-level advisory lock,
+// creating schema_migrations first if needed. The lock is taken on a
+// dedicated connection so it is released on the same one.
+func withMigrationLock(db *sqlx.DB, fn func() error) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
+		}
+	}()
+
+	// Under the lock, so instances booting together don't race to create it
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+	return fn()
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS system_notifications;
+DROP TABLE IF EXISTS like_notifications;
+DROP TABLE IF EXISTS product_owner_notifications;
+DROP TABLE IF EXISTS user_notifications;
+DROP TABLE IF EXISTS users;
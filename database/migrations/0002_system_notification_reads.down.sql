@@ -0,0 +1,3 @@
+ALTER TABLE system_notifications ADD COLUMN IF NOT EXISTS read BOOLEAN DEFAULT FALSE;
+
+DROP TABLE IF EXISTS system_notification_reads;
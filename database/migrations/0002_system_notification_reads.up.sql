@@ -0,0 +1,15 @@
+-- Read/dismiss state for system notifications is tracked per recipient
+-- instead of one flag on the shared row
+CREATE TABLE IF NOT EXISTS system_notification_reads (
+    notification_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    read_at TIMESTAMP,
+    dismissed_at TIMESTAMP,
+    PRIMARY KEY (notification_id, user_id),
+    FOREIGN KEY (notification_id) REFERENCES system_notifications(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_notification_reads_user_id ON system_notification_reads(user_id);
+
+ALTER TABLE system_notifications DROP COLUMN IF EXISTS read;
@@ -0,0 +1,13 @@
+ALTER TABLE system_notifications ADD COLUMN IF NOT EXISTS target_user_ids TEXT;
+
+UPDATE system_notifications s
+SET target_user_ids = COALESCE((
+    SELECT string_agg(r.user_id, ',' ORDER BY r.user_id)
+    FROM system_notification_recipients r
+    WHERE r.notification_id = s.id
+), '');
+
+DROP INDEX IF EXISTS idx_system_notifications_broadcast;
+ALTER TABLE system_notifications DROP COLUMN IF EXISTS broadcast;
+
+DROP TABLE IF EXISTS system_notification_recipients;
@@ -0,0 +1,30 @@
+-- Targeted system notifications list their recipients in their own table so
+-- lookups are exact matches instead of LIKE scans over a comma-separated string
+CREATE TABLE IF NOT EXISTS system_notification_recipients (
+    notification_id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (notification_id, user_id),
+    FOREIGN KEY (notification_id) REFERENCES system_notifications(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_notification_recipients_user_id ON system_notification_recipients(user_id, notification_id);
+
+ALTER TABLE system_notifications ADD COLUMN IF NOT EXISTS broadcast BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE system_notifications
+SET broadcast = TRUE
+WHERE target_user_ids IS NULL OR btrim(target_user_ids) = '';
+
+-- Recipients that no longer exist in users are dropped rather than failing the migration
+INSERT INTO system_notification_recipients (notification_id, user_id)
+SELECT DISTINCT s.id, u.id
+FROM system_notifications s
+CROSS JOIN LATERAL unnest(string_to_array(s.target_user_ids, ',')) AS t(user_id)
+JOIN users u ON u.id = btrim(t.user_id)
+WHERE NOT s.broadcast
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE system_notifications DROP COLUMN IF EXISTS target_user_ids;
+
+CREATE INDEX IF NOT EXISTS idx_system_notifications_broadcast ON system_notifications(created_at) WHERE broadcast;
@@ -0,0 +1,14 @@
+-- Notifications removed by the retention worker in archive mode are kept
+-- here as JSON snapshots instead of being deleted outright
+CREATE TABLE IF NOT EXISTS notification_archive (
+    kind VARCHAR(50) NOT NULL,
+    id VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255),
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (kind, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_archive_user_id ON notification_archive(user_id);
+CREATE INDEX IF NOT EXISTS idx_notification_archive_archived_at ON notification_archive(archived_at);
@@ -0,0 +1,859 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ktappdev/noti-service/models"
+	"github.com/lib/pq"
+)
+
+// PostgresStore implements Store on top of the tables created by the migrations
+type PostgresStore struct {
+	db *sqlx.DB
+}
+
+// NewPostgresStore creates a Store backed by the given connection pool
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// systemVisibleTo matches system notifications (aliased s) that are broadcast
+// or that list the user bound to the given placeholder as a recipient
+func systemVisibleTo(placeholder string) string {
+	return `(s.broadcast OR EXISTS (SELECT 1 FROM system_notification_recipients sr
+	         WHERE sr.notification_id = s.id AND sr.user_id = ` + placeholder + `))`
+}
+
+// systemColumns selects a system notification (aliased s) with its recipient list
+const systemColumns = `s.id, s.title, s.message, s.cta_url, s.icon, s.created_at, s.notification_type,
+	ARRAY(SELECT sr.user_id FROM system_notification_recipients sr WHERE sr.notification_id = s.id ORDER BY sr.user_id) as target_user_ids`
+
+// UpsertUser uses INSERT ... ON CONFLICT so repeated calls are idempotent
+func (s *PostgresStore) UpsertUser(user *models.User) error {
+	query := `
+		INSERT INTO users (id, username, full_name)
+		VALUES ($1, $2, $3)
+		ON CONFLICT ON CONSTRAINT users_pkey DO UPDATE SET
+			username = COALESCE(NULLIF(EXCLUDED.username, ''), users.username),
+			full_name = COALESCE(NULLIF(EXCLUDED.full_name, ''), users.full_name)
+		RETURNING id, username, full_name`
+
+	return s.db.QueryRow(query, user.ID, user.Username, user.FullName).Scan(
+		&user.ID,
+		&user.Username,
+		&user.FullName,
+	)
+}
+
+// UserExists reports whether a user with the given ID exists
+func (s *PostgresStore) UserExists(id string) (bool, error) {
+	var exists bool
+	err := s.db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", id)
+	return exists, err
+}
+
+// CreateUserNotification inserts a comment/reply notification
+func (s *PostgresStore) CreateUserNotification(n *models.UserNotification) error {
+	return insertUserNotification(s.db, n)
+}
+
+// CreateOwnerNotification inserts a product owner notification
+func (s *PostgresStore) CreateOwnerNotification(n *models.ProductOwnerNotification) error {
+	return insertOwnerNotification(s.db, n)
+}
+
+// CreateLikeNotification inserts a like notification with a database-generated ID
+func (s *PostgresStore) CreateLikeNotification(n *models.LikeNotification) error {
+	return insertLikeNotification(s.db, n)
+}
+
+// CreateSystemNotification inserts the notification and its recipients in one transaction
+func (s *PostgresStore) CreateSystemNotification(n *models.SystemNotification) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertSystemNotification(tx, n); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateNotifications inserts every notification of the batch in one transaction
+func (s *PostgresStore) CreateNotifications(batch []NewNotification) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, item := range batch {
+		switch item.Kind {
+		case models.KindUser:
+			err = insertUserNotification(tx, item.User)
+		case models.KindOwner:
+			err = insertOwnerNotification(tx, item.Owner)
+		case models.KindLike:
+			err = insertLikeNotification(tx, item.Like)
+		case models.KindSystem:
+			err = insertSystemNotification(tx, item.System)
+		default:
+			err = fmt.Errorf("unknown notification kind %q", item.Kind)
+		}
+		if err != nil {
+			return fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertUserNotification(e sqlx.Ext, n *models.UserNotification) error {
+	query := `INSERT INTO user_notifications (id, parent_user_id, content, read, notification_type, comment_id, from_id, review_id, parent_id, from_name, product_id)
+	  VALUES (:id, :parent_user_id, :content, :read, :notification_type, :comment_id, :from_id, :review_id, :parent_id, :from_name, :product_id) RETURNING id, created_at`
+	return insertReturning(e, query, n, &n.ID, &n.CreatedAt)
+}
+
+func insertOwnerNotification(e sqlx.Ext, n *models.ProductOwnerNotification) error {
+	query := `INSERT INTO product_owner_notifications (id, owner_id, product_id, product_name, business_id, review_title, from_name, from_id, read, comment_id, review_id, notification_type)
+	              VALUES (:id, :owner_id, :product_id, :product_name, :business_id, :review_title, :from_name, :from_id, :read, :comment_id, :review_id, :notification_type) RETURNING id, created_at`
+	return insertReturning(e, query, n, &n.ID, &n.CreatedAt)
+}
+
+func insertLikeNotification(e sqlx.Ext, n *models.LikeNotification) error {
+	query := `INSERT INTO like_notifications (id, target_user_id, target_type, target_id, from_id, from_name, product_id, read)
+	              VALUES (gen_random_uuid(), :target_user_id, :target_type, :target_id, :from_id, :from_name, :product_id, :read)
+	              RETURNING id, created_at`
+	return insertReturning(e, query, n, &n.ID, &n.CreatedAt)
+}
+
+// insertReturning runs a named INSERT ... RETURNING and scans the first row into dest
+func insertReturning(e sqlx.Ext, query string, arg interface{}, dest ...interface{}) error {
+	rows, err := sqlx.NamedQuery(e, query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// insertSystemNotification inserts the notification and its recipients; callers
+// run it inside a transaction
+func insertSystemNotification(tx *sqlx.Tx, n *models.SystemNotification) error {
+	isBroadcast := len(n.TargetUserIDs) == 0
+	query := `INSERT INTO system_notifications (id, broadcast, title, message, cta_url, icon, notification_type)
+	  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
+	err := tx.QueryRow(query,
+		n.ID,
+		isBroadcast,
+		n.Title,
+		n.Message,
+		n.CtaURL,
+		n.Icon,
+		n.NotificationType,
+	).Scan(&n.ID, &n.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if !isBroadcast {
+		_, err = tx.Exec(`INSERT INTO system_notification_recipients (notification_id, user_id)
+		                  SELECT $1, unnest($2::text[])`, n.ID, n.TargetUserIDs)
+		if err != nil {
+			return fmt.Errorf("inserting recipients: %w", err)
+		}
+	}
+	return nil
+}
+
+// listFilter collects the WHERE conditions of one per-kind list query. Conditions
+// use ? placeholders and the query is rebound for Postgres before running.
+type listFilter struct {
+	conds []string
+	args  []interface{}
+}
+
+func (f *listFilter) add(cond string, args ...interface{}) {
+	f.conds = append(f.conds, cond)
+	f.args = append(f.args, args...)
+}
+
+func (f *listFilter) where() string {
+	return strings.Join(f.conds, " AND ")
+}
+
+// addQuery appends the query's filters for one kind. prefix qualifies the
+// columns and readExpr is the expression holding the row's read state.
+func (f *listFilter) addQuery(q NotificationQuery, kind string, prefix string, readExpr string) {
+	if q.Read != nil {
+		f.add(readExpr+" = ?", *q.Read)
+	}
+	if q.ProductID != "" {
+		f.add(prefix+"product_id = ?", q.ProductID)
+	}
+	if q.FromID != "" {
+		f.add(prefix+"from_id = ?", q.FromID)
+	}
+	// Timestamps are compared as timestamp without time zone, like the columns
+	if q.Since != nil {
+		f.add(prefix+"created_at >= ?::timestamp", q.Since.UTC())
+	}
+	if q.Until != nil {
+		f.add(prefix+"created_at < ?::timestamp", q.Until.UTC())
+	}
+	if c := q.After; c != nil {
+		// Rows of this kind come after the cursor when they are older, or equally
+		// old and ranked after it in the (created_at, kind, id) feed order
+		switch {
+		case kindRank[kind] > kindRank[c.Kind]:
+			f.add(prefix+"created_at < ?::timestamp", c.CreatedAt)
+		case kindRank[kind] < kindRank[c.Kind]:
+			f.add(prefix+"created_at <= ?::timestamp", c.CreatedAt)
+		default:
+			f.add("("+prefix+"created_at < ?::timestamp OR ("+prefix+"created_at = ?::timestamp AND "+prefix+"id < ?))",
+				c.CreatedAt, c.CreatedAt, c.ID)
+		}
+	}
+}
+
+// ListNotifications returns the user's notifications matching the query,
+// newest first. Each kind is fetched with limit+1 rows and the merged result
+// is trimmed to the limit.
+func (s *PostgresStore) ListNotifications(userID string, q NotificationQuery) (*NotificationSet, error) {
+	set := &NotificationSet{}
+	limit := ""
+	if q.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", q.Limit+1)
+	}
+
+	if q.IncludesKind(models.KindUser) {
+		f := &listFilter{}
+		f.add("parent_user_id = ?", userID)
+		f.addQuery(q, models.KindUser, "", "read")
+		query := `SELECT * FROM user_notifications
+		          WHERE ` + f.where() + `
+		          ORDER BY created_at DESC, id DESC` + limit
+		if err := s.db.Select(&set.User, s.db.Rebind(query), f.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.IncludesKind(models.KindOwner) {
+		f := &listFilter{}
+		f.add("owner_id = ?", userID)
+		f.addQuery(q, models.KindOwner, "", "read")
+		query := `SELECT * FROM product_owner_notifications
+		          WHERE ` + f.where() + `
+		          ORDER BY created_at DESC, id DESC` + limit
+		if err := s.db.Select(&set.Owner, s.db.Rebind(query), f.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.IncludesKind(models.KindLike) {
+		f := &listFilter{}
+		f.add("target_user_id = ?", userID)
+		f.addQuery(q, models.KindLike, "", "read")
+		query := `SELECT * FROM like_notifications
+		          WHERE ` + f.where() + `
+		          ORDER BY created_at DESC, id DESC` + limit
+		if err := s.db.Select(&set.Like, s.db.Rebind(query), f.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.IncludesKind(models.KindSystem) {
+		// System notifications are broadcast or targeted, with read state taken
+		// from this user's own read record. The first argument is the join's.
+		f := &listFilter{args: []interface{}{userID}}
+		f.add(systemVisibleTo("?"), userID)
+		f.add("r.dismissed_at IS NULL")
+		f.addQuery(q, models.KindSystem, "s.", "(r.read_at IS NOT NULL)")
+		query := `SELECT ` + systemColumns + `, r.read_at IS NOT NULL as read
+		          FROM system_notifications s
+		          LEFT JOIN system_notification_reads r ON r.notification_id = s.id AND r.user_id = ?
+		          WHERE ` + f.where() + `
+		          ORDER BY s.created_at DESC, s.id DESC` + limit
+		if err := s.db.Select(&set.System, s.db.Rebind(query), f.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	set.page(q.Limit)
+	return set, nil
+}
+
+// CountUnread counts the user's unread notifications of every kind in one round trip
+func (s *PostgresStore) CountUnread(userID string) (*models.NotificationCounts, error) {
+	query := `SELECT
+	    (SELECT count(*) FROM user_notifications WHERE parent_user_id = $1 AND read = false) AS user_count,
+	    (SELECT count(*) FROM product_owner_notifications WHERE owner_id = $1 AND read = false) AS owner_count,
+	    (SELECT count(*) FROM like_notifications WHERE target_user_id = $1 AND read = false) AS like_count,
+	    (SELECT count(*) FROM system_notifications s
+	     LEFT JOIN system_notification_reads r ON r.notification_id = s.id AND r.user_id = $1
+	     WHERE ` + systemVisibleTo("$1") + ` AND r.read_at IS NULL AND r.dismissed_at IS NULL) AS system_count`
+
+	counts := &models.NotificationCounts{}
+	if err := s.db.Get(counts, query, userID); err != nil {
+		return nil, err
+	}
+	counts.Sum()
+	return counts, nil
+}
+
+// MarkRead marks a notification as read and returns the user it belongs to
+func (s *PostgresStore) MarkRead(kind string, id string, userID string) (string, error) {
+	var query string
+	var args []interface{}
+
+	switch kind {
+	case models.KindUser:
+		query = "UPDATE user_notifications SET read = true WHERE id = $1 RETURNING parent_user_id"
+		args = []interface{}{id}
+	case models.KindOwner:
+		query = "UPDATE product_owner_notifications SET read = true WHERE id = $1 RETURNING owner_id"
+		args = []interface{}{id}
+	case models.KindLike:
+		query = "UPDATE like_notifications SET read = true WHERE id = $1 RETURNING target_user_id"
+		args = []interface{}{id}
+	case models.KindSystem:
+		// Record the read for this user only, and only if the notification is visible to them
+		query = `INSERT INTO system_notification_reads (notification_id, user_id, read_at)
+		         SELECT s.id, $2, CURRENT_TIMESTAMP FROM system_notifications s
+		         WHERE s.id = $1
+		           AND EXISTS (SELECT 1 FROM users WHERE id = $2)
+		           AND ` + systemVisibleTo("$2") + `
+		         ON CONFLICT (notification_id, user_id) DO UPDATE
+		         SET read_at = COALESCE(system_notification_reads.read_at, EXCLUDED.read_at)
+		         RETURNING user_id`
+		args = []interface{}{id, userID}
+	default:
+		return "", fmt.Errorf("unknown notification kind %q", kind)
+	}
+
+	var ownerID string
+	if err := s.db.Get(&ownerID, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return ownerID, nil
+}
+
+// notificationTables maps the user-owned kinds to their table and owner column
+var notificationTables = []struct {
+	kind       string
+	table      string
+	userColumn string
+}{
+	{models.KindUser, "user_notifications", "parent_user_id"},
+	{models.KindOwner, "product_owner_notifications", "owner_id"},
+	{models.KindLike, "like_notifications", "target_user_id"},
+}
+
+// FindNotification looks a notification up by ID in each table in turn
+func (s *PostgresStore) FindNotification(id string, userID string) (*models.InboxItem, error) {
+	scope := func(userColumn string) (string, []interface{}) {
+		if userID == "" {
+			return "id = ?", []interface{}{id}
+		}
+		return "id = ? AND " + userColumn + " = ?", []interface{}{id, userID}
+	}
+
+	var user models.UserNotification
+	where, args := scope("parent_user_id")
+	err := s.db.Get(&user, s.db.Rebind("SELECT * FROM user_notifications WHERE "+where), args...)
+	if err == nil {
+		item := models.InboxItemFromUser(user)
+		return &item, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var owner models.ProductOwnerNotification
+	where, args = scope("owner_id")
+	err = s.db.Get(&owner, s.db.Rebind("SELECT * FROM product_owner_notifications WHERE "+where), args...)
+	if err == nil {
+		item := models.InboxItemFromOwner(owner)
+		return &item, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var like models.LikeNotification
+	where, args = scope("target_user_id")
+	err = s.db.Get(&like, s.db.Rebind("SELECT * FROM like_notifications WHERE "+where), args...)
+	if err == nil {
+		item := models.InboxItemFromLike(like)
+		return &item, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// The join's argument comes first; without a user nobody has read it
+	f := &listFilter{args: []interface{}{userID}}
+	f.add("s.id = ?", id)
+	if userID != "" {
+		f.add(systemVisibleTo("?"), userID)
+		f.add("r.dismissed_at IS NULL")
+	}
+	query := `SELECT ` + systemColumns + `, r.read_at IS NOT NULL as read
+	          FROM system_notifications s
+	          LEFT JOIN system_notification_reads r ON r.notification_id = s.id AND r.user_id = ?
+	          WHERE ` + f.where()
+	var system models.SystemNotification
+	err = s.db.Get(&system, s.db.Rebind(query), f.args...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	item := models.InboxItemFromSystem(system)
+	return &item, nil
+}
+
+// MarkAllRead marks the user's matching unread notifications as read in one transaction
+func (s *PostgresStore) MarkAllRead(userID string, q NotificationQuery) (*models.NotificationCounts, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	unread := false
+	q.Read = &unread
+	q.After = nil
+	counts := &models.NotificationCounts{}
+
+	tables := []struct {
+		kind       string
+		table      string
+		userColumn string
+		count      *int
+	}{
+		{models.KindUser, "user_notifications", "parent_user_id", &counts.User},
+		{models.KindOwner, "product_owner_notifications", "owner_id", &counts.Owner},
+		{models.KindLike, "like_notifications", "target_user_id", &counts.Like},
+	}
+	for _, t := range tables {
+		if !q.IncludesKind(t.kind) {
+			continue
+		}
+		f := &listFilter{}
+		f.add(t.userColumn+" = ?", userID)
+		f.addQuery(q, t.kind, "", "read")
+		res, err := tx.Exec(tx.Rebind(`UPDATE `+t.table+` SET read = true WHERE `+f.where()), f.args...)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		*t.count = int(updated)
+	}
+
+	if q.IncludesKind(models.KindSystem) {
+		// Record a read for every visible system notification the user hasn't read or dismissed
+		f := &listFilter{args: []interface{}{userID, userID}}
+		f.add(systemVisibleTo("?"), userID)
+		f.add("r.read_at IS NULL AND r.dismissed_at IS NULL")
+		// An unknown user has nothing to mark rather than failing the foreign key
+		f.add("EXISTS (SELECT 1 FROM users WHERE id = ?)", userID)
+		f.addQuery(q, models.KindSystem, "s.", "(r.read_at IS NOT NULL)")
+		query := `INSERT INTO system_notification_reads (notification_id, user_id, read_at)
+		          SELECT s.id, ?, CURRENT_TIMESTAMP
+		          FROM system_notifications s
+		          LEFT JOIN system_notification_reads r ON r.notification_id = s.id AND r.user_id = ?
+		          WHERE ` + f.where() + `
+		          ON CONFLICT (notification_id, user_id) DO UPDATE SET read_at = EXCLUDED.read_at`
+		res, err := tx.Exec(tx.Rebind(query), f.args...)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		counts.System = int(updated)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	counts.Sum()
+	return counts, nil
+}
+
+// MarkReadByIDs looks up which of the IDs the user can see and marks the
+// unread ones read, all in one transaction
+func (s *PostgresStore) MarkReadByIDs(userID string, ids []string) ([]ReadResult, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	type row struct {
+		ID   string `db:"id"`
+		Read bool   `db:"read"`
+	}
+	found := make(map[string]ReadResult)
+	unread := make(map[string][]string)
+	collect := func(kind string, rows []row) {
+		for _, r := range rows {
+			if _, ok := found[r.ID]; ok {
+				continue
+			}
+			status := ReadAlreadyRead
+			if !r.Read {
+				status = ReadUpdated
+				unread[kind] = append(unread[kind], r.ID)
+			}
+			found[r.ID] = ReadResult{ID: r.ID, Kind: kind, Status: status}
+		}
+	}
+
+	for _, t := range notificationTables {
+		var rows []row
+		query := `SELECT id, read FROM ` + t.table + ` WHERE ` + t.userColumn + ` = $1 AND id = ANY($2)`
+		if err := tx.Select(&rows, query, userID, pq.Array(ids)); err != nil {
+			return nil, err
+		}
+		collect(t.kind, rows)
+	}
+
+	var rows []row
+	query := `SELECT s.id, r.read_at IS NOT NULL as read
+	          FROM system_notifications s
+	          LEFT JOIN system_notification_reads r ON r.notification_id = s.id AND r.user_id = $1
+	          WHERE s.id = ANY($2)
+	            AND r.dismissed_at IS NULL
+	            AND EXISTS (SELECT 1 FROM users WHERE id = $1)
+	            AND ` + systemVisibleTo("$1")
+	if err := tx.Select(&rows, query, userID, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	collect(models.KindSystem, rows)
+
+	for _, t := range notificationTables {
+		if len(unread[t.kind]) == 0 {
+			continue
+		}
+		query := `UPDATE ` + t.table + ` SET read = true WHERE ` + t.userColumn + ` = $1 AND id = ANY($2)`
+		if _, err := tx.Exec(query, userID, pq.Array(unread[t.kind])); err != nil {
+			return nil, err
+		}
+	}
+	if ids := unread[models.KindSystem]; len(ids) > 0 {
+		query := `INSERT INTO system_notification_reads (notification_id, user_id, read_at)
+		          SELECT unnest($2::text[]), $1, CURRENT_TIMESTAMP
+		          ON CONFLICT (notification_id, user_id) DO UPDATE SET read_at = EXCLUDED.read_at`
+		if _, err := tx.Exec(query, userID, pq.Array(ids)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return readResults(ids, found), nil
+}
+
+// DeleteNotification deletes the user's notification from the first table it
+// is found in, falling back to dismissing a system notification
+func (s *PostgresStore) DeleteNotification(id string, userID string) (string, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	for _, t := range notificationTables {
+		res, err := tx.Exec(`DELETE FROM `+t.table+` WHERE id = $1 AND `+t.userColumn+` = $2`, id, userID)
+		if err != nil {
+			return "", err
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return "", err
+		}
+		if deleted > 0 {
+			return t.kind, tx.Commit()
+		}
+	}
+
+	query := `INSERT INTO system_notification_reads (notification_id, user_id, dismissed_at)
+	          SELECT s.id, $2, CURRENT_TIMESTAMP FROM system_notifications s
+	          LEFT JOIN system_notification_reads r ON r.notification_id = s.id AND r.user_id = $2
+	          WHERE s.id = $1
+	            AND r.dismissed_at IS NULL
+	            AND EXISTS (SELECT 1 FROM users WHERE id = $2)
+	            AND ` + systemVisibleTo("$2") + `
+	          ON CONFLICT (notification_id, user_id) DO UPDATE SET dismissed_at = EXCLUDED.dismissed_at`
+	res, err := tx.Exec(query, id, userID)
+	if err != nil {
+		return "", err
+	}
+	dismissed, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if dismissed == 0 {
+		return "", ErrNotFound
+	}
+	return models.KindSystem, tx.Commit()
+}
+
+// DeleteNotifications deletes the user's matching notifications in one
+// transaction and dismisses the matching system notifications
+func (s *PostgresStore) DeleteNotifications(userID string, q NotificationQuery) (*NotificationSet, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q.After = nil
+	set := &NotificationSet{}
+
+	destinations := map[string]interface{}{
+		models.KindUser:  &set.User,
+		models.KindOwner: &set.Owner,
+		models.KindLike:  &set.Like,
+	}
+	for _, t := range notificationTables {
+		if !q.IncludesKind(t.kind) {
+			continue
+		}
+		f := &listFilter{}
+		f.add(t.userColumn+" = ?", userID)
+		f.addQuery(q, t.kind, "", "read")
+		query := `DELETE FROM ` + t.table + ` WHERE ` + f.where() + ` RETURNING *`
+		if err := tx.Select(destinations[t.kind], tx.Rebind(query), f.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.IncludesKind(models.KindSystem) {
+		// Dismiss every matching system notification the user hasn't dismissed yet
+		f := &listFilter{args: []interface{}{userID, userID}}
+		f.add("EXISTS (SELECT 1 FROM users WHERE id = ?)", userID)
+		f.add(systemVisibleTo("?"), userID)
+		f.add("r.dismissed_at IS NULL")
+		f.addQuery(q, models.KindSystem, "s.", "(r.read_at IS NOT NULL)")
+		query := `WITH dismissed AS (
+		              INSERT INTO system_notification_reads (notification_id, user_id, dismissed_at)
+		              SELECT s.id, ?, CURRENT_TIMESTAMP
+		              FROM system_notifications s
+		              LEFT JOIN system_notification_reads r ON r.notification_id = s.id AND r.user_id = ?
+		              WHERE ` + f.where() + `
+		              ON CONFLICT (notification_id, user_id) DO UPDATE SET dismissed_at = EXCLUDED.dismissed_at
+		              RETURNING notification_id, read_at)
+		          SELECT ` + systemColumns + `, d.read_at IS NOT NULL as read
+		          FROM dismissed d JOIN system_notifications s ON s.id = d.notification_id`
+		if err := tx.Select(&set.System, tx.Rebind(query), f.args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	set.sortNewestFirst()
+	return set, nil
+}
+
+// ReserveIdempotencyKey inserts a pending entry for the key, taking over an
+// expired one, and returns the live entry when the insert loses
+func (s *PostgresStore) ReserveIdempotencyKey(key string, scope string, requestHash string, ttl time.Duration) (*IdempotentResponse, error) {
+	query := `INSERT INTO idempotency_keys (key, scope, request_hash, expires_at)
+	          VALUES ($1, $2, $3, CURRENT_TIMESTAMP + $4 * interval '1 second')
+	          ON CONFLICT (key, scope) DO UPDATE SET
+	              request_hash = EXCLUDED.request_hash,
+	              status_code = NULL,
+	              content_type = NULL,
+	              response_body = NULL,
+	              created_at = CURRENT_TIMESTAMP,
+	              completed_at = NULL,
+	              expires_at = EXCLUDED.expires_at
+	          WHERE idempotency_keys.expires_at <= CURRENT_TIMESTAMP
+	          RETURNING key`
+	var reserved string
+	err := s.db.Get(&reserved, query, key, scope, requestHash, ttl.Seconds())
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var row struct {
+		RequestHash string         `db:"request_hash"`
+		StatusCode  sql.NullInt64  `db:"status_code"`
+		ContentType sql.NullString `db:"content_type"`
+		Body        []byte         `db:"response_body"`
+		Completed   bool           `db:"completed"`
+	}
+	query = `SELECT request_hash, status_code, content_type, response_body, completed_at IS NOT NULL as completed
+	         FROM idempotency_keys WHERE key = $1 AND scope = $2`
+	if err := s.db.Get(&row, query, key, scope); err != nil {
+		if err == sql.ErrNoRows {
+			// Released between the insert and the select; let the caller retry
+			return &IdempotentResponse{RequestHash: requestHash}, nil
+		}
+		return nil, err
+	}
+	return &IdempotentResponse{
+		RequestHash: row.RequestHash,
+		StatusCode:  int(row.StatusCode.Int64),
+		ContentType: row.ContentType.String,
+		Body:        row.Body,
+		Completed:   row.Completed,
+	}, nil
+}
+
+// CompleteIdempotencyKey stores the response on the pending entry
+func (s *PostgresStore) CompleteIdempotencyKey(key string, scope string, response IdempotentResponse) error {
+	query := `UPDATE idempotency_keys
+	          SET status_code = $3, content_type = $4, response_body = $5, completed_at = CURRENT_TIMESTAMP
+	          WHERE key = $1 AND scope = $2`
+	_, err := s.db.Exec(query, key, scope, response.StatusCode, response.ContentType, response.Body)
+	return err
+}
+
+// ReleaseIdempotencyKey deletes a pending entry
+func (s *PostgresStore) ReleaseIdempotencyKey(key string, scope string) error {
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE key = $1 AND scope = $2 AND completed_at IS NULL`, key, scope)
+	return err
+}
+
+// PurgeIdempotencyKeys deletes expired idempotency keys
+func (s *PostgresStore) PurgeIdempotencyKeys(dryRun bool) (int64, error) {
+	if dryRun {
+		var count int64
+		err := s.db.Get(&count, `SELECT count(*) FROM idempotency_keys WHERE expires_at <= CURRENT_TIMESTAMP`)
+		return count, err
+	}
+	res, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// retentionTable describes where notifications of one kind live
+type retentionTable struct {
+	name       string
+	userColumn string
+}
+
+var retentionTables = map[string]retentionTable{
+	models.KindUser:   {"user_notifications", "parent_user_id"},
+	models.KindOwner:  {"product_owner_notifications", "owner_id"},
+	models.KindLike:   {"like_notifications", "target_user_id"},
+	models.KindSystem: {"system_notifications", ""}, // shared between users
+}
+
+// PurgeNotifications applies a retention policy. Dry runs execute the same
+// statements in a transaction that is rolled back, so the counts are exact.
+func (s *PostgresStore) PurgeNotifications(policy RetentionPolicy, dryRun bool) (*RetentionResult, error) {
+	table, ok := retentionTables[policy.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown notification kind %q", policy.Kind)
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &RetentionResult{Kind: policy.Kind}
+
+	if policy.Kind == models.KindSystem {
+		// Read state is per user, so expiring a read hides it from that user only
+		if policy.ReadBefore != nil {
+			res, err := tx.Exec(`UPDATE system_notification_reads SET dismissed_at = CURRENT_TIMESTAMP
+			                     WHERE read_at < $1::timestamp AND dismissed_at IS NULL`, policy.ReadBefore.UTC())
+			if err != nil {
+				return nil, err
+			}
+			if result.ReadExpired, err = res.RowsAffected(); err != nil {
+				return nil, err
+			}
+		}
+		if policy.UnreadBefore != nil {
+			result.UnreadExpired, err = purgeRows(tx, policy.Kind, table, "created_at < $1::timestamp", []interface{}{policy.UnreadBefore.UTC()}, policy.Archive)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if policy.ReadBefore != nil {
+			result.ReadExpired, err = purgeRows(tx, policy.Kind, table, "read = true AND created_at < $1::timestamp", []interface{}{policy.ReadBefore.UTC()}, policy.Archive)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if policy.UnreadBefore != nil {
+			result.UnreadExpired, err = purgeRows(tx, policy.Kind, table, "read = false AND created_at < $1::timestamp", []interface{}{policy.UnreadBefore.UTC()}, policy.Archive)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if policy.MaxPerUser > 0 {
+			overLimit := `id IN (SELECT id FROM (
+			                  SELECT id, row_number() OVER (PARTITION BY ` + table.userColumn + ` ORDER BY created_at DESC, id DESC) AS rn
+			                  FROM ` + table.name + `) ranked
+			              WHERE rn > $1)`
+			result.OverLimit, err = purgeRows(tx, policy.Kind, table, overLimit, []interface{}{policy.MaxPerUser}, policy.Archive)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// purgeRows deletes the rows matching where, copying them into
+// notification_archive first when archive is set
+func purgeRows(tx *sqlx.Tx, kind string, table retentionTable, where string, args []interface{}, archive bool) (int64, error) {
+	query := `DELETE FROM ` + table.name + ` WHERE ` + where
+	if archive {
+		userExpr := "NULL"
+		if table.userColumn != "" {
+			userExpr = "removed." + table.userColumn
+		}
+		args = append(args, kind)
+		query = fmt.Sprintf(`WITH removed AS (%s RETURNING *)
+		          INSERT INTO notification_archive (kind, id, user_id, payload, created_at)
+		          SELECT $%d, removed.id, %s, to_jsonb(removed), removed.created_at FROM removed
+		          ON CONFLICT (kind, id) DO UPDATE
+		          SET payload = EXCLUDED.payload, archived_at = CURRENT_TIMESTAMP`,
+			query, len(args), userExpr)
+	}
+
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
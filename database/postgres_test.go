@@ -0,0 +1,51 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ktappdev/noti-service/models"
+	_ "github.com/lib/pq"
+)
+
+// testPostgresStore connects to TEST_DATABASE_URL and migrates it, skipping
+// the test when it isn't set
+func testPostgresStore(t *testing.T) (*PostgresStore, *sqlx.DB) {
+	t.Helper()
+
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sqlx.Connect("postgres", connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return NewPostgresStore(db), db
+}
+
+func TestMarkAllReadUnknownUser(t *testing.T) {
+	postgres, db := testPostgresStore(t)
+
+	// A broadcast is visible to every user ID, known or not
+	id := fmt.Sprintf("test_mark_all_read_%d", time.Now().UnixNano())
+	if err := postgres.CreateSystemNotification(&models.SystemNotification{ID: id, Title: "t", Message: "m"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM system_notifications WHERE id = $1`, id) })
+
+	counts, err := postgres.MarkAllRead("test_no_such_user", NotificationQuery{})
+	if err != nil {
+		t.Fatalf("MarkAllRead for an unknown user: %v", err)
+	}
+	if counts.Total != 0 {
+		t.Errorf("MarkAllRead for an unknown user marked %+v, want nothing", counts)
+	}
+}
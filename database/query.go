@@ -0,0 +1,199 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+// ErrInvalidCursor is returned when a cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// AllKinds lists every notification kind in feed order for equal timestamps
+var AllKinds = []string{models.KindUser, models.KindOwner, models.KindLike, models.KindSystem}
+
+// kindRank breaks created_at ties between kinds so the feed order is total
+var kindRank = map[string]int{
+	models.KindUser:   0,
+	models.KindOwner:  1,
+	models.KindLike:   2,
+	models.KindSystem: 3,
+}
+
+// NotificationQuery filters and pages a user's notifications. Zero values
+// mean "no filter"; a zero Limit returns everything.
+type NotificationQuery struct {
+	Kinds     []string
+	Read      *bool
+	ProductID string
+	FromID    string
+	Since     *time.Time // created_at >= Since
+	Until     *time.Time // created_at < Until
+	Limit     int
+	After     *Cursor
+}
+
+// Unread returns a query for every unread notification
+func Unread() NotificationQuery {
+	read := false
+	return NotificationQuery{Read: &read}
+}
+
+// IncludesKind reports whether the query selects notifications of the given kind.
+// System notifications have no product or sender, so those filters exclude them.
+func (q NotificationQuery) IncludesKind(kind string) bool {
+	if kind == models.KindSystem && (q.ProductID != "" || q.FromID != "") {
+		return false
+	}
+	if len(q.Kinds) == 0 {
+		return true
+	}
+	for _, k := range q.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Cursor is a position in a user's feed, which is ordered newest first by
+// (created_at, kind, id)
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	Kind      string    `json:"k"`
+	ID        string    `json:"i"`
+}
+
+// Encode returns the opaque string handed to clients as next_cursor
+func (c Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// DecodeCursor parses a cursor produced by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if _, ok := kindRank[c.Kind]; !ok || c.CreatedAt.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Includes reports whether an item sorts at or before the cursor, i.e. was
+// already returned on an earlier page
+func (c Cursor) Includes(createdAt time.Time, kind string, id string) bool {
+	return !newer(feedEntry{Kind: c.Kind, CreatedAt: c.CreatedAt, ID: c.ID}, feedEntry{Kind: kind, CreatedAt: createdAt, ID: id})
+}
+
+// feedEntry locates one notification of a NotificationSet in feed order
+type feedEntry struct {
+	Kind      string
+	Index     int
+	CreatedAt time.Time
+	ID        string
+}
+
+// feed returns every notification in the set ordered newest first by (created_at, kind, id)
+func (set *NotificationSet) feed() []feedEntry {
+	entries := make([]feedEntry, 0, len(set.User)+len(set.Owner)+len(set.Like)+len(set.System))
+	for i, n := range set.User {
+		entries = append(entries, feedEntry{models.KindUser, i, n.CreatedAt, n.ID})
+	}
+	for i, n := range set.Owner {
+		entries = append(entries, feedEntry{models.KindOwner, i, n.CreatedAt, n.ID})
+	}
+	for i, n := range set.Like {
+		entries = append(entries, feedEntry{models.KindLike, i, n.CreatedAt, n.ID})
+	}
+	for i, n := range set.System {
+		entries = append(entries, feedEntry{models.KindSystem, i, n.CreatedAt, n.ID})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return newer(entries[i], entries[j])
+	})
+	return entries
+}
+
+// newer reports whether a comes before b in feed order
+func newer(a, b feedEntry) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	if kindRank[a.Kind] != kindRank[b.Kind] {
+		return kindRank[a.Kind] > kindRank[b.Kind]
+	}
+	return a.ID > b.ID
+}
+
+// page keeps the first limit notifications in feed order and sets NextCursor
+// when more remain. Each kind must already be sorted newest first.
+func (set *NotificationSet) page(limit int) {
+	if limit <= 0 {
+		return
+	}
+	entries := set.feed()
+	if len(entries) <= limit {
+		return
+	}
+
+	kept := make(map[string]int)
+	for _, e := range entries[:limit] {
+		kept[e.Kind]++
+	}
+	last := entries[limit-1]
+	set.NextCursor = &Cursor{CreatedAt: last.CreatedAt, Kind: last.Kind, ID: last.ID}
+
+	set.User = set.User[:kept[models.KindUser]]
+	set.Owner = set.Owner[:kept[models.KindOwner]]
+	set.Like = set.Like[:kept[models.KindLike]]
+	set.System = set.System[:kept[models.KindSystem]]
+}
+
+// InboxItems returns the set as one feed of envelopes, newest first
+func (set *NotificationSet) InboxItems() []models.InboxItem {
+	entries := set.feed()
+	items := make([]models.InboxItem, 0, len(entries))
+	for _, e := range entries {
+		switch e.Kind {
+		case models.KindUser:
+			items = append(items, models.InboxItemFromUser(set.User[e.Index]))
+		case models.KindOwner:
+			items = append(items, models.InboxItemFromOwner(set.Owner[e.Index]))
+		case models.KindLike:
+			items = append(items, models.InboxItemFromLike(set.Like[e.Index]))
+		case models.KindSystem:
+			items = append(items, models.InboxItemFromSystem(set.System[e.Index]))
+		}
+	}
+	return items
+}
+
+// readResults orders MarkReadByIDs outcomes like the requested IDs, reporting
+// duplicates once and IDs missing from found as not found
+func readResults(ids []string, found map[string]ReadResult) []ReadResult {
+	results := make([]ReadResult, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result, ok := found[id]
+		if !ok {
+			result = ReadResult{ID: id, Status: ReadNotFound}
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+// ErrNotFound is returned when a notification doesn't exist or isn't visible to the user
+var ErrNotFound = errors.New("notification not found")
+
+// NotificationSet holds a user's notifications grouped by kind, newest first
+type NotificationSet struct {
+	User   []models.UserNotification
+	Owner  []models.ProductOwnerNotification
+	Like   []models.LikeNotification
+	System []models.SystemNotification
+	// NextCursor is set when a limited query has more notifications to return
+	NextCursor *Cursor
+}
+
+// NewNotification is one item of a CreateNotifications batch. The field
+// matching Kind holds the notification.
+type NewNotification struct {
+	Kind   string
+	User   *models.UserNotification
+	Owner  *models.ProductOwnerNotification
+	Like   *models.LikeNotification
+	System *models.SystemNotification
+}
+
+// Outcomes of marking one notification read in MarkReadByIDs
+const (
+	ReadUpdated     = "updated"
+	ReadAlreadyRead = "already_read"
+	ReadNotFound    = "not_found"
+)
+
+// ReadResult reports what MarkReadByIDs did with one ID
+type ReadResult struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind,omitempty"`
+	Status string `json:"status"`
+}
+
+// IdempotentResponse is what the store keeps for a request made with an
+// idempotency key
+type IdempotentResponse struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	// Completed is false while the first request with the key is still being handled
+	Completed bool
+}
+
+// RetentionPolicy selects notifications of one kind that are due for removal.
+// Nil cutoffs and a zero MaxPerUser disable that part of the policy.
+type RetentionPolicy struct {
+	Kind string
+	// ReadBefore removes read notifications created before this time. For
+	// system notifications it dismisses per-user reads made before it instead.
+	ReadBefore *time.Time
+	// UnreadBefore removes unread notifications created before this time. System
+	// notifications are shared, so for them it removes the row for everyone.
+	UnreadBefore *time.Time
+	// MaxPerUser keeps only the newest N notifications of this kind per user.
+	// It doesn't apply to system notifications.
+	MaxPerUser int
+	// Archive copies removed rows into notification_archive before deleting them
+	Archive bool
+}
+
+// RetentionResult counts what a RetentionPolicy removed (or would remove)
+type RetentionResult struct {
+	Kind          string `json:"kind"`
+	ReadExpired   int64  `json:"read_expired"`
+	UnreadExpired int64  `json:"unread_expired"`
+	OverLimit     int64  `json:"over_limit"`
+}
+
+// Total is the number of notifications affected across every rule
+func (r RetentionResult) Total() int64 {
+	return r.ReadExpired + r.UnreadExpired + r.OverLimit
+}
+
+// Store is the persistence layer behind the HTTP handlers. PostgresStore is
+// used in production and MemoryStore for tests and local development.
+type Store interface {
+	// UpsertUser creates the user or refreshes its non-empty fields
+	UpsertUser(user *models.User) error
+	// UserExists reports whether a user with the given ID exists
+	UserExists(id string) (bool, error)
+
+	// CreateUserNotification stores a comment/reply notification and fills in ID and CreatedAt
+	CreateUserNotification(n *models.UserNotification) error
+	// CreateOwnerNotification stores a product owner notification and fills in ID and CreatedAt
+	CreateOwnerNotification(n *models.ProductOwnerNotification) error
+	// CreateLikeNotification stores a like notification with a generated ID
+	CreateLikeNotification(n *models.LikeNotification) error
+	// CreateSystemNotification stores a system notification and its recipients;
+	// no TargetUserIDs means a broadcast to every user
+	CreateSystemNotification(n *models.SystemNotification) error
+	// CreateNotifications stores a batch of notifications of mixed kinds in one
+	// transaction, filling in IDs and CreatedAt. Either all are stored or none.
+	CreateNotifications(batch []NewNotification) error
+
+	// ListNotifications returns the notifications visible to the user that
+	// match the query, newest first across all kinds
+	ListNotifications(userID string, query NotificationQuery) (*NotificationSet, error)
+
+	// CountUnread returns the user's unread totals per kind
+	CountUnread(userID string) (*models.NotificationCounts, error)
+
+	// FindNotification looks a notification up by ID alone, trying each kind
+	// in AllKinds order. With a userID only notifications visible to that user
+	// match and system read state is theirs; without one any owner matches.
+	FindNotification(id string, userID string) (*models.InboxItem, error)
+
+	// MarkRead marks one notification of the given kind as read and returns the
+	// user it belongs to. System notifications are marked read for userID only.
+	MarkRead(kind string, id string, userID string) (string, error)
+
+	// MarkAllRead marks every unread notification of the user matching the
+	// query's Kinds, ProductID, FromID, Since and Until filters as read in one
+	// transaction and returns how many were updated per kind
+	MarkAllRead(userID string, query NotificationQuery) (*models.NotificationCounts, error)
+
+	// MarkReadByIDs marks the user's notifications with the given IDs as read in
+	// one transaction, whatever their kind, and reports the outcome per ID in
+	// the order given. Duplicate IDs are reported once.
+	MarkReadByIDs(userID string, ids []string) ([]ReadResult, error)
+
+	// DeleteNotification deletes one of the user's notifications by ID and
+	// returns its kind. System notifications are shared, so for them it
+	// dismisses the notification for userID only.
+	DeleteNotification(id string, userID string) (string, error)
+
+	// DeleteNotifications deletes the user's notifications matching the query's
+	// Kinds, Read, ProductID, FromID, Since and Until filters in one transaction
+	// and returns what was removed. System notifications are dismissed instead.
+	DeleteNotifications(userID string, query NotificationQuery) (*NotificationSet, error)
+
+	// ReserveIdempotencyKey claims an idempotency key within scope for ttl. It
+	// returns nil when the key was free or expired and is now reserved, and the
+	// stored entry when the key is already taken.
+	ReserveIdempotencyKey(key string, scope string, requestHash string, ttl time.Duration) (*IdempotentResponse, error)
+	// CompleteIdempotencyKey stores the response for a reserved key
+	CompleteIdempotencyKey(key string, scope string, response IdempotentResponse) error
+	// ReleaseIdempotencyKey drops a reservation so the request can be retried
+	ReleaseIdempotencyKey(key string, scope string) error
+	// PurgeIdempotencyKeys removes expired idempotency keys and returns how many
+	// there were. With dryRun set they are only counted.
+	PurgeIdempotencyKeys(dryRun bool) (int64, error)
+
+	// PurgeNotifications applies a retention policy. With dryRun set nothing
+	// is changed but the result reports what would have been removed.
+	PurgeNotifications(policy RetentionPolicy, dryRun bool) (*RetentionResult, error)
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/models"
+	"github.com/ktappdev/noti-service/sse"
+	"github.com/ktappdev/noti-service/validate"
+)
+
+// maxBatchItems caps the number of notifications in one batch request
+const maxBatchItems = 100
+
+// Batch modes
+const (
+	// batchAtomic stores every item or none of them
+	batchAtomic = "atomic"
+	// batchPartial stores the valid items and reports the failures
+	batchPartial = "partial"
+)
+
+// Outcomes of one batch item
+const (
+	batchCreated = "created"
+	batchSkipped = "skipped" // a self-like, which never creates a notification
+	batchFailed  = "failed"
+	batchAborted = "aborted" // valid, but not stored because another item failed in atomic mode
+)
+
+// batchTypeField is the batch item field naming the notification type; the
+// rest of the item is a notification body
+const batchTypeField = "type"
+
+// batchRequest is the body of CreateNotificationBatch
+type batchRequest struct {
+	Mode  string            `json:"mode" validate:"oneof=atomic partial"`
+	Items []json.RawMessage `json:"items" validate:"required"`
+}
+
+// batchItem is a validated batch item waiting to be stored
+type batchItem struct {
+	index        int
+	notification database.NewNotification
+}
+
+// batchResult reports what happened to one batch item
+type batchResult struct {
+	Index        int            `json:"index"`
+	Type         string         `json:"type"`
+	Status       string         `json:"status"`
+	Reason       string         `json:"reason,omitempty"`
+	Error        *ErrorResponse `json:"error,omitempty"`
+	Notification interface{}    `json:"notification,omitempty"`
+}
+
+// CreateNotificationBatch creates notifications of mixed types from one
+// request. Each item is a notification body as accepted by the single create
+// endpoints plus a "type" of comment, reply, owner, like or system, and is
+// validated by the same rules. In atomic mode (the default) every item is
+// stored in one transaction, or none if any item fails; in partial mode valid
+// items are stored and failures are reported per item. Stored notifications
+// are pushed to their recipients' streams as usual.
+func CreateNotificationBatch(store database.Store, hub *sse.SSEHub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		request := new(batchRequest)
+		errs, err := decodeBody(c.Body(), request, dedupeKeyField)
+		if err != nil {
+			return err
+		}
+		if len(request.Items) > maxBatchItems {
+			return apperr.BadRequest(apperr.CodeBatchTooLarge, fmt.Sprintf("items can contain at most %d notifications", maxBatchItems)).WithField("items")
+		}
+		if err := validationError(append(errs, validate.Struct(request)...)); err != nil {
+			return err
+		}
+		if request.Mode == "" {
+			request.Mode = batchAtomic
+		}
+
+		results := make([]batchResult, len(request.Items))
+		var valid []batchItem
+		var firstFailure *apperr.Error
+		failedIndex := 0
+
+		for i, raw := range request.Items {
+			item, kind, err := prepareBatchItem(store, raw)
+			results[i] = batchResult{Index: i, Type: kind}
+			switch {
+			case err == errSelfLike:
+				results[i].Status = batchSkipped
+				results[i].Reason = err.Error()
+			case err != nil:
+				failure := toAppError(err)
+				if failure.Status >= 500 {
+					log.Printf("Error preparing batch item %d: %v", i, err)
+				}
+				results[i].Status = batchFailed
+				results[i].Error = &ErrorResponse{Code: failure.Code, Message: failure.Message, Field: failure.Field, Errors: failure.Errors}
+				if firstFailure == nil {
+					firstFailure, failedIndex = failure, i
+				}
+			default:
+				valid = append(valid, batchItem{index: i, notification: item})
+			}
+		}
+
+		var stored []batchItem
+		if request.Mode == batchAtomic {
+			if firstFailure != nil {
+				for _, item := range valid {
+					results[item.index].Status = batchAborted
+				}
+				// The error body gains the per-item results, and its fields
+				// are named relative to the request
+				prefix := fmt.Sprintf("items[%d]", failedIndex)
+				field := prefix
+				if firstFailure.Field != "" {
+					field += "." + firstFailure.Field
+				}
+				requestID, _ := c.Locals("requestid").(string)
+				body := fiber.Map{
+					"code":       firstFailure.Code,
+					"message":    fmt.Sprintf("Batch rejected, item %d failed: %s", failedIndex, firstFailure.Message),
+					"field":      field,
+					"request_id": requestID,
+					"mode":       request.Mode,
+					"created":    0,
+					"results":    results,
+				}
+				if len(firstFailure.Errors) > 0 {
+					errs := make([]apperr.FieldError, len(firstFailure.Errors))
+					for j, fieldErr := range firstFailure.Errors {
+						fieldErr.Field = prefix + "." + fieldErr.Field
+						errs[j] = fieldErr
+					}
+					body["errors"] = errs
+				}
+				return c.Status(firstFailure.Status).JSON(body)
+			}
+			batch := make([]database.NewNotification, len(valid))
+			for j, item := range valid {
+				batch[j] = item.notification
+			}
+			if err := store.CreateNotifications(batch); err != nil {
+				return apperr.Internal("Failed to create notifications", err)
+			}
+			stored = valid
+		} else {
+			// Each item gets its own transaction so one failure doesn't undo the rest
+			for _, item := range valid {
+				if err := store.CreateNotifications([]database.NewNotification{item.notification}); err != nil {
+					log.Printf("Error inserting batch item %d: %v", item.index, err)
+					results[item.index].Status = batchFailed
+					results[item.index].Error = &ErrorResponse{Code: apperr.CodeInternal, Message: "Failed to create notification"}
+					continue
+				}
+				stored = append(stored, item)
+			}
+		}
+
+		// Fan out every new notification, then refresh each recipient's counts once
+		recipients := make(map[string]bool)
+		for _, item := range stored {
+			notification := batchNotification(item.notification)
+			results[item.index].Status = batchCreated
+			results[item.index].Notification = notification
+			userIDs, _ := broadcastNewNotification(hub, item.notification.Kind, notification)
+			for _, userID := range userIDs {
+				recipients[userID] = true
+			}
+		}
+		userIDs := make([]string, 0, len(recipients))
+		for userID := range recipients {
+			userIDs = append(userIDs, userID)
+		}
+		publishUnreadCounts(store, hub, userIDs, "")
+		created := len(stored)
+
+		status := 201
+		if created < len(request.Items) && request.Mode == batchPartial {
+			status = 207
+		}
+		return c.Status(status).JSON(batchResponse{
+			Mode:    request.Mode,
+			Created: created,
+			Results: results,
+		})
+	}
+}
+
+// prepareBatchItem decodes one batch item by its type and validates it with
+// the matching prepare function. It returns the item's type even on failure.
+func prepareBatchItem(store database.Store, raw json.RawMessage) (database.NewNotification, string, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return database.NewNotification{}, "", apperr.InvalidBody(err)
+	}
+
+	switch header.Type {
+	case "comment", "reply":
+		n := new(models.UserNotification)
+		errs, err := decodeBody(raw, n, batchTypeField)
+		if err != nil {
+			return database.NewNotification{}, header.Type, err
+		}
+		prepare := prepareCommentNotification
+		if header.Type == "reply" {
+			prepare = prepareReplyNotification
+		}
+		return database.NewNotification{Kind: models.KindUser, User: n}, header.Type, prepare(store, n, errs)
+	case "owner":
+		n := new(models.ProductOwnerNotification)
+		errs, err := decodeBody(raw, n, batchTypeField)
+		if err != nil {
+			return database.NewNotification{}, header.Type, err
+		}
+		return database.NewNotification{Kind: models.KindOwner, Owner: n}, header.Type, prepareOwnerNotification(store, n, errs)
+	case "like":
+		n := new(models.LikeNotification)
+		errs, err := decodeBody(raw, n, batchTypeField)
+		if err != nil {
+			return database.NewNotification{}, header.Type, err
+		}
+		return database.NewNotification{Kind: models.KindLike, Like: n}, header.Type, prepareLikeNotification(store, n, errs)
+	case "system":
+		n := new(models.SystemNotification)
+		errs, err := decodeBody(raw, n, batchTypeField)
+		if err != nil {
+			return database.NewNotification{}, header.Type, err
+		}
+		return database.NewNotification{Kind: models.KindSystem, System: n}, header.Type, prepareSystemNotification(store, n, errs)
+	default:
+		return database.NewNotification{}, header.Type, apperr.BadRequest(apperr.CodeInvalidNotificationType, "type must be one of comment, reply, owner, like or system").WithField("type")
+	}
+}
+
+// batchNotification returns the notification held by a batch item
+func batchNotification(item database.NewNotification) interface{} {
+	switch item.Kind {
+	case models.KindUser:
+		return item.User
+	case models.KindOwner:
+		return item.Owner
+	case models.KindLike:
+		return item.Like
+	default:
+		return item.System
+	}
+}
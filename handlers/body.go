@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/validate"
+)
+
+// decodeBody decodes a JSON object into v, which must point to a struct.
+// Keys that aren't fields of v, or one of the allowed extra names, are
+// returned as field errors rather than failing outright, so they can be
+// reported along with the rest of the request's validation errors.
+func decodeBody(data []byte, v interface{}, allowed ...string) ([]apperr.FieldError, error) {
+	unknown, err := validate.UnknownFields(data, v, allowed...)
+	if err != nil {
+		return nil, apperr.InvalidBody(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return nil, apperr.ValidationFailed(append(unknown, apperr.FieldError{
+				Field: typeErr.Field, Rule: "type", Message: typeErr.Field + " must be a " + jsonType(typeErr.Type.Kind())}))
+		}
+		return nil, apperr.InvalidBody(err)
+	}
+	return unknown, nil
+}
+
+// validationError returns a VALIDATION_FAILED error listing errs, or nil if
+// there are none
+func validationError(errs []apperr.FieldError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apperr.ValidationFailed(errs)
+}
+
+// jsonType names the JSON type a Go kind decodes from
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "number"
+	}
+}
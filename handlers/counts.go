@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/sse"
+)
+
+// GetUnreadCounts returns the user's unread totals per kind and overall, so
+// badges don't need to download the notifications themselves
+func GetUnreadCounts(store database.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Query("user_id")
+		if userID == "" {
+			return apperr.MissingParameter("user_id")
+		}
+
+		counts, err := store.CountUnread(userID)
+		if err != nil {
+			return apperr.Internal("Failed to count unread notifications", err)
+		}
+
+		return c.JSON(counts)
+	}
+}
+
+// publishUnreadCount pushes the user's current unread counts to their open
+// streams as an unread_count event. notificationType is the kind that changed.
+func publishUnreadCount(store database.Store, hub *sse.SSEHub, userID string, notificationType string) {
+	// Skip the query when nobody is listening. With a bridge the user may be
+	// connected to another instance, which only gets the counts from here.
+	if !hub.Bridged() && !hub.HasClients(userID) {
+		return
+	}
+
+	counts, err := store.CountUnread(userID)
+	if err != nil {
+		log.Printf("Error counting unread notifications for %s: %v", userID, err)
+		return
+	}
+	hub.BroadcastToUser(userID, "unread_count", notificationType, counts)
+}
+
+// PublishRemoteBroadcastCounts follows broadcast system notifications from
+// other instances with the unread counts of the users they reached here,
+// which the sending instance can't see. Call it before the hub's bridge runs.
+func PublishRemoteBroadcastCounts(store database.Store, hub *sse.SSEHub) {
+	hub.OnRemoteBroadcast(func(event string, notificationType string, result sse.BroadcastResult) {
+		if event == "new_notification" {
+			publishUnreadCounts(store, hub, result.UserIDs, notificationType)
+		}
+	})
+}
+
+// unreadCountSlots bounds how many background unread count queries run at
+// once across all fan-outs
+var unreadCountSlots = make(chan struct{}, 8)
+
+// publishUnreadCounts pushes each user's unread counts to their open streams.
+// A single user's are sent before returning. More, e.g. everyone connected
+// after a broadcast system notification, are sent in the background so the
+// request doesn't wait on a query per user, with at most cap(unreadCountSlots)
+// queries running at a time.
+func publishUnreadCounts(store database.Store, hub *sse.SSEHub, userIDs []string, notificationType string) {
+	if len(userIDs) <= 1 {
+		for _, userID := range userIDs {
+			publishUnreadCount(store, hub, userID, notificationType)
+		}
+		return
+	}
+
+	go func() {
+		for _, userID := range userIDs {
+			unreadCountSlots <- struct{}{}
+			go func(userID string) {
+				defer func() { <-unreadCountSlots }()
+				publishUnreadCount(store, hub, userID, notificationType)
+			}(userID)
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/sse"
+)
+
+// sharedBridge stands in for the Postgres bridge between instances, handing
+// every published event straight to each hub
+type sharedBridge struct {
+	hubs []*sse.SSEHub
+}
+
+func (b *sharedBridge) Publish(event sse.Event) {
+	for _, hub := range b.hubs {
+		hub.Receive(event)
+	}
+}
+
+// waitForEvent reads the client's frames until one for event arrives
+func waitForEvent(t *testing.T, client *sse.SSEClient, event string) []byte {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case frame := <-client.Channel:
+			if bytes.Contains(frame, []byte("event: "+event+"\n")) {
+				return frame
+			}
+		case <-timeout:
+			t.Fatalf("no %s event for client %s", event, client.ID)
+			return nil
+		}
+	}
+}
+
+func TestUnreadCountReachesOtherInstances(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+		want string
+	}{
+		{"comment", "/v1/notifications/comment",
+			`{"id":"n1","parent_user_id":"user_b","from_id":"user_a","content":"Nice review"}`, `"user":1`},
+		{"system broadcast", "/v1/notifications/system",
+			`{"id":"s1","title":"Maintenance","message":"Down at noon"}`, `"system":1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Two instances sharing a store and a bridge
+			store := database.NewMemoryStore()
+			bridge := &sharedBridge{}
+			var apps []*fiber.App
+			for i := 0; i < 2; i++ {
+				hub := sse.NewSSEHub()
+				hub.SetBridge(bridge)
+				PublishRemoteBroadcastCounts(store, hub)
+				go hub.Run()
+				defer hub.Close()
+				bridge.hubs = append(bridge.hubs, hub)
+				app, _ := newTestAPIWithHub(store, hub)
+				apps = append(apps, app)
+			}
+			createUsers(t, apps[0], "user_a", "user_b")
+
+			// user_b is connected to the second instance only
+			client := bridge.hubs[1].NewClient("user_b", sse.FormatEvents)
+			bridge.hubs[1].RegisterClient(client)
+
+			if status, res := send(t, apps[0], fiber.MethodPost, tt.path, tt.body); status != fiber.StatusCreated {
+				t.Fatalf("POST %s: got %d %v", tt.path, status, res)
+			}
+			waitForEvent(t, client, "new_notification")
+			if frame := waitForEvent(t, client, "unread_count"); !bytes.Contains(frame, []byte(tt.want)) {
+				t.Errorf("unread_count = %s, want %s", frame, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/apperr"
+)
+
+// ErrorResponse is the JSON body of every error response. Errors lists each
+// failed field of a VALIDATION_FAILED error.
+type ErrorResponse struct {
+	Code      apperr.Code         `json:"code"`
+	Message   string              `json:"message"`
+	Field     string              `json:"field,omitempty"`
+	Errors    []apperr.FieldError `json:"errors,omitempty"`
+	RequestID string              `json:"request_id,omitempty"`
+}
+
+// ErrorHandler is the app's fiber.ErrorHandler. It renders the errors
+// returned by handlers as an ErrorResponse, turning anything that isn't an
+// *apperr.Error into an internal error so causes never leak to clients.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	appErr := toAppError(err)
+	if appErr.Status >= 500 {
+		log.Printf("%s %s failed: %v", c.Method(), c.Path(), err)
+	}
+
+	requestID, _ := c.Locals("requestid").(string)
+	return c.Status(appErr.Status).JSON(ErrorResponse{
+		Code:      appErr.Code,
+		Message:   appErr.Message,
+		Field:     appErr.Field,
+		Errors:    appErr.Errors,
+		RequestID: requestID,
+	})
+}
+
+// toAppError converts any error returned by a handler to an *apperr.Error
+func toAppError(err error) *apperr.Error {
+	var appErr *apperr.Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	// Errors raised by Fiber itself, such as unknown routes
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		switch fiberErr.Code {
+		case fiber.StatusNotFound:
+			return apperr.New(fiberErr.Code, apperr.CodeNotFound, fiberErr.Message)
+		case fiber.StatusMethodNotAllowed:
+			return apperr.New(fiberErr.Code, apperr.CodeMethodNotAllowed, fiberErr.Message)
+		case fiber.StatusServiceUnavailable:
+			return apperr.New(fiberErr.Code, apperr.CodeServiceUnavailable, fiberErr.Message)
+		}
+		if fiberErr.Code < 500 {
+			return apperr.New(fiberErr.Code, apperr.CodeBadRequest, fiberErr.Message)
+		}
+	}
+
+	return apperr.Internal("Internal server error", err)
+}
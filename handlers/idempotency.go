@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
+)
+
+// IdempotencyKeyHeader carries the client's idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// dedupeKeyField is the body field that can carry the idempotency key instead
+// of the header. Create handlers accept it alongside their own fields.
+const dedupeKeyField = "dedupe_key"
+
+// defaultIdempotencyTTL is how long keys are remembered unless IDEMPOTENCY_TTL is set
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// IdempotencyTTLFromEnv reads IDEMPOTENCY_TTL as a Go duration, e.g. "12h"
+func IdempotencyTTLFromEnv() time.Duration {
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Invalid IDEMPOTENCY_TTL %q, using %s", v, defaultIdempotencyTTL)
+	}
+	return defaultIdempotencyTTL
+}
+
+// Idempotency makes a create route safe to retry. Requests carrying an
+// Idempotency-Key header, or a dedupe_key field in the JSON body, run once per
+// key and route, whether sent to its /v1 path or the unversioned alias;
+// repeats within ttl get the stored response back with an
+// Idempotent-Replayed header and never reach the handler, so nothing is
+// stored or broadcast twice. A repeat that arrives while the first request is
+// still running gets 409, and reusing a key for a different body gets 422.
+// Error responses, 4xx as well as 5xx, release the key instead of being
+// stored, so a retry runs the request again rather than replaying the error.
+func Idempotency(store database.Store, ttl time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := idempotencyKey(c)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > 255 {
+			return apperr.Invalid(IdempotencyKeyHeader, "Idempotency key must be at most 255 characters")
+		}
+
+		// The route pattern without its version, so a retry sent to the /v1
+		// route or its unversioned alias finds the same key
+		scope := c.Method() + " " + strings.TrimPrefix(c.Route().Path, "/v1")
+		sum := sha256.Sum256(c.Body())
+		requestHash := hex.EncodeToString(sum[:])
+
+		existing, err := store.ReserveIdempotencyKey(key, scope, requestHash, ttl)
+		if err != nil {
+			return apperr.Internal("Failed to check idempotency key", err)
+		}
+		if existing != nil {
+			if existing.RequestHash != requestHash {
+				return apperr.New(422, apperr.CodeIdempotencyKeyMismatch, "Idempotency key was already used for a different request")
+			}
+			if !existing.Completed {
+				return apperr.New(409, apperr.CodeIdempotencyKeyInProgress, "A request with this idempotency key is still in progress")
+			}
+			c.Set("Idempotent-Replayed", "true")
+			if existing.ContentType != "" {
+				c.Set(fiber.HeaderContentType, existing.ContentType)
+			}
+			return c.Status(existing.StatusCode).Send(existing.Body)
+		}
+
+		if err := c.Next(); err != nil {
+			releaseIdempotencyKey(store, key, scope)
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status >= 400 {
+			releaseIdempotencyKey(store, key, scope)
+			return nil
+		}
+
+		response := database.IdempotentResponse{
+			StatusCode:  status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        append([]byte(nil), c.Response().Body()...),
+		}
+		if err := store.CompleteIdempotencyKey(key, scope, response); err != nil {
+			log.Printf("Error storing response for idempotency key %q: %v", key, err)
+		}
+		return nil
+	}
+}
+
+// idempotencyKey returns the request's Idempotency-Key header, falling back to
+// a dedupe_key field in a JSON body
+func idempotencyKey(c *fiber.Ctx) string {
+	if key := c.Get(IdempotencyKeyHeader); key != "" {
+		return key
+	}
+	var body struct {
+		DedupeKey string `json:"dedupe_key"`
+	}
+	if json.Unmarshal(c.Body(), &body) != nil {
+		return ""
+	}
+	return body.DedupeKey
+}
+
+func releaseIdempotencyKey(store database.Store, key string, scope string) {
+	if err := store.ReleaseIdempotencyKey(key, scope); err != nil {
+		log.Printf("Error releasing idempotency key %q: %v", key, err)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/database"
+)
+
+func TestIdempotencyKeySharedWithLegacyAlias(t *testing.T) {
+	store := database.NewMemoryStore()
+	app, _ := newTestAPIWithStore(store)
+	createUsers(t, app, "user_a", "user_b")
+	body := `{"id":"n1","parent_user_id":"user_b","from_id":"user_a","content":"Nice review"}`
+
+	for i, path := range []string{"/v1/notifications/comment", "/notifications/comment"} {
+		req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(IdempotencyKeyHeader, "retry-1")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusCreated {
+			t.Fatalf("POST %s: got %d, want 201", path, res.StatusCode)
+		}
+		if replayed := res.Header.Get("Idempotent-Replayed") == "true"; replayed != (i > 0) {
+			t.Errorf("POST %s: Idempotent-Replayed = %v, want %v", path, replayed, i > 0)
+		}
+	}
+
+	set, err := store.ListNotifications("user_b", database.Unread())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.User) != 1 {
+		t.Errorf("stored %d notifications, want 1", len(set.User))
+	}
+}
+
+func TestIdempotencyKeyReleasedOnError(t *testing.T) {
+	store := database.NewMemoryStore()
+	app, _ := newTestAPIWithStore(store)
+	body := `{"id":"n1","parent_user_id":"user_b","from_id":"user_a","content":"Nice review"}`
+
+	post := func() *http.Response {
+		req := httptest.NewRequest(fiber.MethodPost, "/v1/notifications/comment", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		req.Header.Set(IdempotencyKeyHeader, "retry-1")
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// The recipient doesn't exist yet, so the first attempt fails with a 4xx
+	if res := post(); res.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("first attempt: got %d, want 400", res.StatusCode)
+	}
+
+	createUsers(t, app, "user_a", "user_b")
+	res := post()
+	if res.StatusCode != fiber.StatusCreated {
+		t.Fatalf("retry: got %d, want 201", res.StatusCode)
+	}
+	if res.Header.Get("Idempotent-Replayed") != "" {
+		t.Error("retry replayed the error instead of running the request")
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
+)
+
+// GetInbox returns one feed of every notification kind, newest first, using the
+// common InboxItem envelope. It accepts the filters and paging parameters read
+// by parseNotificationQuery.
+func GetInbox(store database.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Query("user_id")
+		if userID == "" {
+			return apperr.MissingParameter("user_id")
+		}
+
+		query, err := parseNotificationQuery(c)
+		if err != nil {
+			return err
+		}
+
+		set, err := store.ListNotifications(userID, query)
+		if err != nil {
+			return apperr.Internal("Failed to list notifications", err)
+		}
+
+		return c.JSON(newNotificationFeed(set))
+	}
+}
@@ -1,698 +1,535 @@
 package handlers
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/jmoiron/sqlx"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
 	"github.com/ktappdev/noti-service/models"
-	"github.com/ktappdev/noti-service/reviewit"
 	"github.com/ktappdev/noti-service/sse"
+	"github.com/ktappdev/noti-service/validate"
 )
 
-// parseCommaSeparatedString converts a comma-separated string to a Go slice
-func parseCommaSeparatedString(str string) []string {
-	if str == "" {
-		return []string{}
-	}
-	
-	return strings.Split(str, ",")
-}
-
 // CreateProductOwnerNotification creates a new product owner notification
-func CreateProductOwnerNotification(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
+func CreateProductOwnerNotification(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		fmt.Println("createProductOwnerNotification")
 		notification := new(models.ProductOwnerNotification)
-		if err := c.BodyParser(notification); err != nil {
-			return c.Status(400).SendString(err.Error())
-		}
-
-		// Set the notification type
-		notification.NotificationType = "review"
-		fmt.Println("this is the notification", notification)
-
-		// Check if the owner exists
-		var exists bool
-		err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", notification.OwnerID)
+		errs, err := decodeBody(c.Body(), notification, dedupeKeyField)
 		if err != nil {
-			log.Println(err)
-			return c.Status(500).SendString(err.Error())
-		}
-		if !exists {
-			log.Println("owner does not exist")
-			return c.Status(400).SendString("Owner does not exist")
+			return err
 		}
 
-		query := `INSERT INTO product_owner_notifications (id, owner_id, product_id, product_name, business_id, review_title, from_name, from_id, read, comment_id, review_id, notification_type)
-	              VALUES (:id, :owner_id, :product_id, :product_name, :business_id, :review_title, :from_name, :from_id, :read, :comment_id, :review_id, :notification_type) RETURNING id, created_at`
-		rows, err := db.NamedQuery(query, notification)
-		if err != nil {
-			log.Printf("Error creating notification: %v", err)
-			return c.Status(500).SendString(err.Error())
+		if err := prepareOwnerNotification(store, notification, errs); err != nil {
+			return err
 		}
-		defer rows.Close()
+		fmt.Println("this is the notification", notification)
 
-		if rows.Next() {
-			err = rows.Scan(&notification.ID, &notification.CreatedAt)
-			if err != nil {
-				log.Printf("Error scanning notification result: %v", err)
-				return c.Status(500).SendString(err.Error())
-			}
+		if err := store.CreateOwnerNotification(notification); err != nil {
+			return apperr.Internal("Failed to create owner notification", err)
 		}
 
 		// Broadcast to SSE clients
-		hub.BroadcastToUser(notification.OwnerID, "new_notification", "owner", notification)
+		publishNewNotification(store, hub, models.KindOwner, notification)
 
 		return c.Status(201).JSON(notification)
 	}
 }
 
 // CreateCommentNotification creates a new comment notification (for comments on reviews)
-func CreateCommentNotification(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
+func CreateCommentNotification(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		notification := new(models.UserNotification)
-		if err := c.BodyParser(notification); err != nil {
-			return c.Status(400).SendString(err.Error())
-		}
-
-		// Set the notification type
-		notification.NotificationType = "comment"
-		log.Printf("Creating comment notification: %+v", notification)
-
-		// For comments on reviews, target_user_id should be provided directly
-		if notification.ParentUserID == "" {
-			return c.Status(400).SendString("target_user_id (parent_user_id) is required for comment notifications")
-		}
-
-		// Check if the target user exists
-		var exists bool
-		err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", notification.ParentUserID)
+		errs, err := decodeBody(c.Body(), notification, dedupeKeyField)
 		if err != nil {
-			log.Printf("Error checking target user existence: %v", err)
-			return c.Status(500).SendString("Internal server error")
-		}
-		if !exists {
-			log.Printf("Target user %s does not exist", notification.ParentUserID)
-			return c.Status(400).SendString("Target user does not exist. Please ensure the user is created in the notification service first.")
+			return err
 		}
 
-		// Check if the from user exists
-		err = db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", notification.FromID)
-		if err != nil {
-			log.Printf("Error checking from user existence: %v", err)
-			return c.Status(500).SendString("Internal server error")
-		}
-		if !exists {
-			log.Printf("From user %s does not exist", notification.FromID)
-			return c.Status(400).SendString("From user does not exist. Please ensure the user is created in the notification service first.")
+		log.Printf("Creating comment notification: %+v", notification)
+		if err := prepareCommentNotification(store, notification, errs); err != nil {
+			return err
 		}
 
 		// Insert the notification
-		query := `INSERT INTO user_notifications (id, parent_user_id, content, read, notification_type, comment_id, from_id, review_id, parent_id, from_name, product_id)
-	  VALUES (:id, :parent_user_id, :content, :read, :notification_type, :comment_id, :from_id, :review_id, :parent_id, :from_name, :product_id) RETURNING id, created_at`
-		rows, err := db.NamedQuery(query, notification)
-		if err != nil {
-			log.Printf("Error inserting comment notification: %v", err)
-			return c.Status(500).SendString("Failed to create comment notification")
-		}
-		defer rows.Close()
-
-		if rows.Next() {
-			err = rows.Scan(&notification.ID, &notification.CreatedAt)
-			if err != nil {
-				log.Printf("Error scanning comment notification result: %v", err)
-				return c.Status(500).SendString("Failed to retrieve created comment notification")
-			}
+		if err := store.CreateUserNotification(notification); err != nil {
+			return apperr.Internal("Failed to create comment notification", err)
 		}
 
 		// Broadcast to SSE clients
-		hub.BroadcastToUser(notification.ParentUserID, "new_notification", "user", notification)
+		publishNewNotification(store, hub, models.KindUser, notification)
 
 		return c.Status(201).JSON(notification)
 	}
 }
 
 // CreateReplyNotification creates a new reply notification (for replies to comments)
-func CreateReplyNotification(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
+func CreateReplyNotification(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		notification := new(models.UserNotification)
-		if err := c.BodyParser(notification); err != nil {
-			return c.Status(400).SendString(err.Error())
-		}
-
-		// Set the notification type
-		notification.NotificationType = "reply"
-		log.Printf("Creating reply notification: %+v", notification)
-
-		// For replies, parent_user_id should be provided directly by the frontend
-		// If not provided, try to look it up from the parent comment
-		if notification.ParentUserID == "" {
-			if notification.ParentID == "" {
-				return c.Status(400).SendString("Either parent_user_id or parent_id (comment ID) is required for reply notifications")
-			}
-
-			// ParentID should be a comment ID, look up the user who made that comment
-			parentUserID, err := reviewit.GetParentCommentUserID(notification.ParentID)
-			if err != nil {
-				log.Printf("ERROR getting parent user ID from comment %s: %v", notification.ParentID, err)
-				// Check if it's a missing environment variable error
-				if err.Error() == "REVIEWIT_DATABASE_URL environment variable is required" {
-					return c.Status(500).SendString("ReviewIt database connection not configured. Please set REVIEWIT_DATABASE_URL environment variable.")
-				}
-				return c.Status(500).SendString(fmt.Sprintf("Error getting parent user ID from comment: %v", err))
-			}
-			notification.ParentUserID = parentUserID
-			log.Printf("Looked up user ID from comment %s: %s", notification.ParentID, parentUserID)
-		}
-
-		// Check if the target user exists
-		var exists bool
-		err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", notification.ParentUserID)
+		errs, err := decodeBody(c.Body(), notification, dedupeKeyField)
 		if err != nil {
-			log.Printf("Error checking target user existence: %v", err)
-			return c.Status(500).SendString("Internal server error")
-		}
-		if !exists {
-			log.Printf("Target user %s does not exist", notification.ParentUserID)
-			return c.Status(400).SendString("Target user does not exist. Please ensure the user is created in the notification service first.")
+			return err
 		}
 
-		// Check if the from user exists
-		err = db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", notification.FromID)
-		if err != nil {
-			log.Printf("Error checking from user existence: %v", err)
-			return c.Status(500).SendString("Internal server error")
-		}
-		if !exists {
-			log.Printf("From user %s does not exist", notification.FromID)
-			return c.Status(400).SendString("From user does not exist. Please ensure the user is created in the notification service first.")
+		log.Printf("Creating reply notification: %+v", notification)
+		if err := prepareReplyNotification(store, notification, errs); err != nil {
+			return err
 		}
 
 		// Insert the notification
-		query := `INSERT INTO user_notifications (id, parent_user_id, content, read, notification_type, comment_id, from_id, review_id, parent_id, from_name, product_id)
-	  VALUES (:id, :parent_user_id, :content, :read, :notification_type, :comment_id, :from_id, :review_id, :parent_id, :from_name, :product_id) RETURNING id, created_at`
-		rows, err := db.NamedQuery(query, notification)
-		if err != nil {
-			log.Printf("Error inserting reply notification: %v", err)
-			return c.Status(500).SendString("Failed to create reply notification")
-		}
-		defer rows.Close()
-
-		if rows.Next() {
-			err = rows.Scan(&notification.ID, &notification.CreatedAt)
-			if err != nil {
-				log.Printf("Error scanning reply notification result: %v", err)
-				return c.Status(500).SendString("Failed to retrieve created reply notification")
-			}
+		if err := store.CreateUserNotification(notification); err != nil {
+			return apperr.Internal("Failed to create reply notification", err)
 		}
 
 		// Broadcast to SSE clients
-		hub.BroadcastToUser(notification.ParentUserID, "new_notification", "user", notification)
+		publishNewNotification(store, hub, models.KindUser, notification)
 
 		return c.Status(201).JSON(notification)
 	}
 }
 
-// CreateSystemNotification creates a new system notification
-func CreateSystemNotification(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
+// CreateSystemNotification creates a new system notification. No
+// target_user_ids means a broadcast to all users.
+func CreateSystemNotification(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		notification := new(models.SystemNotification)
-		if err := c.BodyParser(notification); err != nil {
-			return c.Status(400).SendString(err.Error())
+		errs, err := decodeBody(c.Body(), notification, dedupeKeyField)
+		if err != nil {
+			return err
 		}
 
-		// Set the notification type
-		notification.NotificationType = "system"
 		log.Printf("Creating system notification: %+v", notification)
-
-		// Validate required fields
-		if notification.Title == "" {
-			return c.Status(400).SendString("title is required for system notifications")
-		}
-		if notification.Message == "" {
-			return c.Status(400).SendString("message is required for system notifications")
-		}
-
-		// If target_user_ids is empty or nil, this is a broadcast to all users
-		isBroadcast := len(notification.TargetUserIDsArray) == 0
-
-		if !isBroadcast {
-			// Check if all target users exist
-			for _, userID := range notification.TargetUserIDsArray {
-				var exists bool
-				err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID)
-				if err != nil {
-					log.Printf("Error checking user existence for %s: %v", userID, err)
-					return c.Status(500).SendString("Internal server error")
-				}
-				if !exists {
-					log.Printf("Target user %s does not exist", userID)
-					return c.Status(400).SendString(fmt.Sprintf("Target user %s does not exist. Please ensure the user is created in the notification service first.", userID))
-				}
-			}
+		if err := prepareSystemNotification(store, notification, errs); err != nil {
+			return err
 		}
 
-		// Convert target_user_ids to comma-separated string
-		var targetUserIDsString string
-		if isBroadcast {
-			targetUserIDsString = "" // Empty string means broadcast to all
-		} else {
-			targetUserIDsString = strings.Join(notification.TargetUserIDsArray, ",")
+		// Insert the notification and its recipients together
+		if err := store.CreateSystemNotification(notification); err != nil {
+			return apperr.Internal("Failed to create system notification", err)
 		}
 
-		// Insert the notification
-		query := `INSERT INTO system_notifications (id, target_user_ids, title, message, cta_url, icon, read, notification_type)
-	  VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`
-		
-		err := db.QueryRow(query, 
-			notification.ID, 
-			targetUserIDsString, 
-			notification.Title, 
-			notification.Message, 
-			notification.CtaURL, 
-			notification.Icon, 
-			notification.Read, 
-			notification.NotificationType,
-		).Scan(&notification.ID, &notification.CreatedAt)
-		
-		if err != nil {
-			log.Printf("Error inserting system notification: %v", err)
-			return c.Status(500).SendString("Failed to create system notification")
-		}
-
-		// Set the array field for JSON response
-		notification.TargetUserIDsArray = notification.TargetUserIDsArray
-
 		// Broadcast to SSE clients
-		if isBroadcast {
-			// Broadcast to all connected users
-			hub.BroadcastToAll("new_notification", "system", notification)
-			log.Printf("Broadcasting system notification to all users")
-		} else {
-			// Send to specific users
-			for _, userID := range notification.TargetUserIDsArray {
-				hub.BroadcastToUser(userID, "new_notification", "system", notification)
-				log.Printf("Sending system notification to user: %s", userID)
-			}
+		result := systemNotificationResult{SystemNotification: notification}
+		if reach := publishNewNotification(store, hub, models.KindSystem, notification); reach != nil {
+			result.Reach = &broadcastReach{Users: len(reach.UserIDs), Clients: reach.Clients, Dropped: reach.Dropped}
 		}
 
-		return c.Status(201).JSON(notification)
+		return c.Status(201).JSON(result)
 	}
 }
 
 // CreateLikeNotification creates a new like notification
-func CreateLikeNotification(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
+func CreateLikeNotification(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		notification := new(models.LikeNotification)
-		if err := c.BodyParser(notification); err != nil {
-			return c.Status(400).SendString(err.Error())
+		errs, err := decodeBody(c.Body(), notification, dedupeKeyField)
+		if err != nil {
+			return err
 		}
 
 		log.Printf("Creating like notification: %+v", notification)
-
-		// Validate target_type
-		if notification.TargetType != "comment" && notification.TargetType != "review" {
-			return c.Status(400).SendString("target_type must be 'comment' or 'review'")
+		err = prepareLikeNotification(store, notification, errs)
+		if err == errSelfLike {
+			// Don't create notification if user likes their own content
+			return c.Status(200).JSON(skippedNotification{Skipped: true, Reason: err.Error()})
 		}
-
-		// Get the target user ID based on target type and ID
-		var targetUserID string
-		var err error
-
-		if notification.TargetType == "comment" {
-			// Check if TargetID is already a user ID or a comment ID
-			if len(notification.TargetID) > 5 && notification.TargetID[:5] == "user_" {
-				// TargetID is already a user ID, use it directly
-				targetUserID = notification.TargetID
-				log.Printf("Using TargetID as user ID directly for comment: %s", targetUserID)
-			} else {
-				// TargetID is a comment ID, look up the user who made that comment
-				targetUserID, err = reviewit.GetCommentUserID(notification.TargetID)
-				if err != nil {
-					log.Printf("ERROR getting comment user ID: %v", err)
-					if err.Error() == "REVIEWIT_DATABASE_URL environment variable is required" {
-						return c.Status(500).SendString("ReviewIt database connection not configured. Please set REVIEWIT_DATABASE_URL environment variable.")
-					}
-					return c.Status(500).SendString(fmt.Sprintf("Error getting comment user ID: %v", err))
-				}
-				log.Printf("Looked up user ID from comment: %s", targetUserID)
-			}
-		} else if notification.TargetType == "review" {
-			// Check if TargetID is already a user ID or a review ID
-			if len(notification.TargetID) > 5 && notification.TargetID[:5] == "user_" {
-				// TargetID is already a user ID, use it directly
-				targetUserID = notification.TargetID
-				log.Printf("Using TargetID as user ID directly for review: %s", targetUserID)
-			} else {
-				// TargetID is a review ID, look up the user who made that review
-				targetUserID, err = reviewit.GetReviewUserID(notification.TargetID)
-				if err != nil {
-					log.Printf("ERROR getting review user ID: %v", err)
-					if err.Error() == "REVIEWIT_DATABASE_URL environment variable is required" {
-						return c.Status(500).SendString("ReviewIt database connection not configured. Please set REVIEWIT_DATABASE_URL environment variable.")
-					}
-					return c.Status(500).SendString(fmt.Sprintf("Error getting review user ID: %v", err))
-				}
-				log.Printf("Looked up user ID from review: %s", targetUserID)
-			}
-		}
-
-		notification.TargetUserID = targetUserID
-
-		// Check if the target user exists
-		var exists bool
-		err = db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", notification.TargetUserID)
-		if err != nil {
-			log.Printf("Error checking target user existence: %v", err)
-			return c.Status(500).SendString("Internal server error")
-		}
-		if !exists {
-			log.Println("target user doesn't exist")
-			return c.Status(400).SendString("Target user does not exist")
-		}
-
-		// Check if the from user exists
-		err = db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", notification.FromID)
 		if err != nil {
-			log.Printf("Error checking from user existence: %v", err)
-			return c.Status(500).SendString("Internal server error")
-		}
-		if !exists {
-			log.Println("from user doesn't exist")
-			return c.Status(400).SendString("From user does not exist")
-		}
-
-		// Don't create notification if user likes their own content
-		if notification.TargetUserID == notification.FromID {
-			return c.Status(200).SendString("No notification created for self-like")
+			return err
 		}
 
 		// Insert the notification
-		query := `INSERT INTO like_notifications (id, target_user_id, target_type, target_id, from_id, from_name, product_id, read)
-	              VALUES (gen_random_uuid(), :target_user_id, :target_type, :target_id, :from_id, :from_name, :product_id, :read) 
-	              RETURNING id, created_at`
-		
-		rows, err := db.NamedQuery(query, notification)
-		if err != nil {
-			log.Printf("Error inserting like notification: %v", err)
-			return c.Status(500).SendString("Failed to create like notification")
-		}
-		defer rows.Close()
-
-		if rows.Next() {
-			err = rows.Scan(&notification.ID, &notification.CreatedAt)
-			if err != nil {
-				log.Printf("Error scanning like notification result: %v", err)
-				return c.Status(500).SendString("Failed to retrieve created like notification")
-			}
+		if err := store.CreateLikeNotification(notification); err != nil {
+			return apperr.Internal("Failed to create like notification", err)
 		}
 
 		// Broadcast to SSE clients
-		hub.BroadcastToUser(notification.TargetUserID, "new_notification", "like", notification)
+		publishNewNotification(store, hub, models.KindLike, notification)
 
 		return c.Status(201).JSON(notification)
 	}
 }
 
-// GetLatestNotifications gets the latest notifications for a user
-func GetLatestNotifications(db *sqlx.DB) fiber.Handler {
+// defaultLatestLimit is how many notifications GetLatestNotifications returns
+// when no limit is given
+const defaultLatestLimit = 10
+
+// GetLatestNotifications returns the user's newest notifications across every
+// kind in the InboxItem envelope. limit picks how many (default
+// defaultLatestLimit, up to maxPageSize) and the filters of
+// parseNotificationFilters apply, e.g. read=false for unread only. next_cursor
+// continues the feed in /v1/inbox.
+func GetLatestNotifications(store database.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Query("user_id")
 		if userID == "" {
-			return c.Status(400).SendString("user_id query parameter is required")
+			return apperr.MissingParameter("user_id")
 		}
 
-		userQuery := `SELECT * FROM user_notifications
-	                  WHERE user_id = $1
-	                  ORDER BY created_at DESC
-	                  LIMIT 1`
-		var userNotification models.UserNotification
-		err := db.Get(&userNotification, userQuery, userID)
-		if err != nil && err != sql.ErrNoRows {
-			return c.Status(500).SendString(err.Error())
+		query, err := parseNotificationFilters(c)
+		if err != nil {
+			return err
 		}
-
-		ownerQuery := `SELECT * FROM product_owner_notifications
-	                   WHERE owner_id = $1
-	                   ORDER BY created_at DESC
-	                   LIMIT 1`
-		var ownerNotification models.ProductOwnerNotification
-		err = db.Get(&ownerNotification, ownerQuery, userID)
-		if err != nil && err != sql.ErrNoRows {
-			return c.Status(500).SendString(err.Error())
+		query.Limit = defaultLatestLimit
+		if limit := c.Query("limit"); limit != "" {
+			query.Limit, err = strconv.Atoi(limit)
+			if err != nil || query.Limit < 1 || query.Limit > maxPageSize {
+				return apperr.InvalidParameter("limit", fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			}
 		}
 
-		if userNotification.ID == "" && ownerNotification.ID == "" {
-			return c.Status(200).SendString("No notifications found")
+		set, err := store.ListNotifications(userID, query)
+		if err != nil {
+			return apperr.Internal("Failed to list notifications", err)
 		}
 
-		return c.JSON(fiber.Map{
-			"user_notification":  userNotification,
-			"owner_notification": ownerNotification,
-		})
+		return c.JSON(newNotificationFeed(set))
 	}
 }
 
-// GetAllNotifications gets all notifications for a user
-func GetAllNotifications(db *sqlx.DB) fiber.Handler {
+// GetAllNotifications gets all notifications for a user. The list can be
+// filtered and paged with the parameters read by parseNotificationQuery.
+func GetAllNotifications(store database.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Query("user_id")
 		if userID == "" {
-			return c.Status(400).SendString("user_id query parameter is required")
+			return apperr.MissingParameter("user_id")
 		}
 
-		userQuery := `SELECT * FROM user_notifications
-	                  WHERE parent_user_id = $1
-	                  ORDER BY created_at DESC`
-		var userNotifications []models.UserNotification
-		err := db.Select(&userNotifications, userQuery, userID)
+		query, err := parseNotificationQuery(c)
 		if err != nil {
-			return c.Status(500).SendString(err.Error())
+			return err
 		}
 
-		ownerQuery := `SELECT * FROM product_owner_notifications
-	                   WHERE owner_id = $1
-	                   ORDER BY created_at DESC`
-		var ownerNotifications []models.ProductOwnerNotification
-		err = db.Select(&ownerNotifications, ownerQuery, userID)
+		set, err := store.ListNotifications(userID, query)
 		if err != nil {
-			return c.Status(500).SendString(err.Error())
+			return apperr.Internal("Failed to list notifications", err)
 		}
 
-		likeQuery := `SELECT * FROM like_notifications
-	                  WHERE target_user_id = $1
-	                  ORDER BY created_at DESC`
-		var likeNotifications []models.LikeNotification
-		err = db.Select(&likeNotifications, likeQuery, userID)
-		if err != nil {
-			return c.Status(500).SendString(err.Error())
+		return c.JSON(newNotificationLists(set))
+	}
+}
+
+// GetAllUnreadNotifications gets all unread notifications for a user. It
+// accepts the same filters and paging as GetAllNotifications except read.
+func GetAllUnreadNotifications(store database.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Query("user_id")
+		if userID == "" {
+			return apperr.MissingParameter("user_id")
 		}
 
-		// Get system notifications (broadcast to all or specifically targeted to this user)
-		systemQuery := `SELECT id, COALESCE(target_user_ids, '') as target_user_ids, title, message, cta_url, icon, read, created_at, notification_type 
-	                    FROM system_notifications
-	                    WHERE target_user_ids = '' OR target_user_ids IS NULL OR target_user_ids LIKE '%' || $1 || '%'
-	                    ORDER BY created_at DESC`
-		var systemNotifications []models.SystemNotification
-		err = db.Select(&systemNotifications, systemQuery, userID)
+		query, err := parseNotificationQuery(c)
 		if err != nil {
-			return c.Status(500).SendString(err.Error())
+			return err
 		}
+		unread := false
+		query.Read = &unread
 
-		// Convert PostgreSQL arrays to Go slices for JSON response
-		for i := range systemNotifications {
-			systemNotifications[i].TargetUserIDsArray = parseCommaSeparatedString(systemNotifications[i].TargetUserIDs)
+		set, err := store.ListNotifications(userID, query)
+		if err != nil {
+			return apperr.Internal("Failed to list notifications", err)
 		}
 
-		return c.JSON(fiber.Map{
-			"user_notifications":   userNotifications,
-			"owner_notifications":  ownerNotifications,
-			"like_notifications":   likeNotifications,
-			"system_notifications": systemNotifications,
-		})
+		return c.JSON(newNotificationLists(set))
 	}
 }
 
-// GetAllUnreadNotifications gets all unread notifications for a user
-func GetAllUnreadNotifications(db *sqlx.DB) fiber.Handler {
+// DeleteNotifications deletes a user's notifications matching the filters of
+// parseNotificationFilters. read defaults to true, so with no filters every
+// read notification is deleted; pass read=any to include unread ones. System
+// notifications are shared and are dismissed for this user instead.
+func DeleteNotifications(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Query("user_id")
 		if userID == "" {
-			return c.Status(400).SendString("user_id query parameter is required")
+			return apperr.MissingParameter("user_id")
 		}
 
-		userQuery := `SELECT * FROM user_notifications
-	                  WHERE parent_user_id = $1 AND read = false
-	                  ORDER BY created_at DESC`
-		var userNotifications []models.UserNotification
-		err := db.Select(&userNotifications, userQuery, userID)
+		q, err := parseNotificationFilters(c)
 		if err != nil {
-			return c.Status(500).SendString(err.Error())
+			return err
 		}
-
-		ownerQuery := `SELECT * FROM product_owner_notifications
-	                   WHERE owner_id = $1 AND read = false
-	                   ORDER BY created_at DESC`
-		var ownerNotifications []models.ProductOwnerNotification
-		err = db.Select(&ownerNotifications, ownerQuery, userID)
-		if err != nil {
-			return c.Status(500).SendString(err.Error())
-		}
-
-		likeQuery := `SELECT * FROM like_notifications
-	                  WHERE target_user_id = $1 AND read = false
-	                  ORDER BY created_at DESC`
-		var likeNotifications []models.LikeNotification
-		err = db.Select(&likeNotifications, likeQuery, userID)
-		if err != nil {
-			return c.Status(500).SendString(err.Error())
+		if c.Query("read") == "" {
+			read := true
+			q.Read = &read
 		}
 
-		// Get unread system notifications (broadcast to all or specifically targeted to this user)
-		systemQuery := `SELECT id, COALESCE(target_user_ids, '') as target_user_ids, title, message, cta_url, icon, read, created_at, notification_type 
-	                    FROM system_notifications
-	                    WHERE (target_user_ids = '' OR target_user_ids IS NULL OR target_user_ids LIKE '%' || $1 || '%') AND read = false
-	                    ORDER BY created_at DESC`
-		var systemNotifications []models.SystemNotification
-		err = db.Select(&systemNotifications, systemQuery, userID)
+		deleted, err := store.DeleteNotifications(userID, q)
 		if err != nil {
-			return c.Status(500).SendString(err.Error())
+			return apperr.Internal("Failed to delete notifications", err)
 		}
 
-		// Convert PostgreSQL arrays to Go slices for JSON response
-		for i := range systemNotifications {
-			systemNotifications[i].TargetUserIDsArray = parseCommaSeparatedString(systemNotifications[i].TargetUserIDs)
+		counts := &models.NotificationCounts{
+			User:   len(deleted.User),
+			Owner:  len(deleted.Owner),
+			Like:   len(deleted.Like),
+			System: len(deleted.System),
 		}
+		counts.Sum()
 
-		return c.JSON(fiber.Map{
-			"user_notifications":   userNotifications,
-			"owner_notifications":  ownerNotifications,
-			"like_notifications":   likeNotifications,
-			"system_notifications": systemNotifications,
+		if counts.Total > 0 {
+			items := deleted.InboxItems()
+			removed := make([]deletedNotification, 0, len(items))
+			for _, item := range items {
+				removed = append(removed, deletedNotification{ID: item.ID, Type: item.Kind})
+			}
+			publishDeleted(store, hub, userID, removed, counts)
+		}
+
+		return c.JSON(deleteNotificationsResult{
+			Deleted:                    counts,
+			DeletedUserNotifications:   len(deleted.User),
+			DeletedOwnerNotifications:  len(deleted.Owner),
+			DeletedLikeNotifications:   len(deleted.Like),
+			DeletedSystemNotifications: len(deleted.System),
+			User:                       deleted.User,
+			Owner:                      deleted.Owner,
+			Like:                       deleted.Like,
+			System:                     deleted.System,
 		})
 	}
 }
 
-// DeleteReadNotifications deletes all read notifications for a user
-func DeleteReadNotifications(db *sqlx.DB) fiber.Handler {
+// DeleteNotification deletes one of the user's notifications by ID, or
+// dismisses it for them when it is a system notification
+func DeleteNotification(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		notificationID := c.Params("id")
 		userID := c.Query("user_id")
 		if userID == "" {
-			return c.Status(400).SendString("user_id query parameter is required")
+			return apperr.MissingParameter("user_id")
 		}
 
-		userQuery := `DELETE FROM user_notifications WHERE parent_user_id = $1 AND read = true RETURNING *`
-		var deletedUserNotifications []models.UserNotification
-		err := db.Select(&deletedUserNotifications, userQuery, userID)
-		if err != nil {
-			return c.Status(500).SendString(err.Error())
+		kind, err := store.DeleteNotification(notificationID, userID)
+		if err == database.ErrNotFound {
+			// Tell apart someone else's notification from a missing one. System
+			// notifications have no owner, so those are just not found.
+			if item, findErr := store.FindNotification(notificationID, ""); findErr == nil && item.Kind != models.KindSystem {
+				return apperr.New(403, apperr.CodeNotificationNotOwned, "Notification belongs to another user")
+			}
+			return apperr.NotificationNotFound()
 		}
-
-		ownerQuery := `DELETE FROM product_owner_notifications WHERE owner_id = $1 AND read = true RETURNING *`
-		var deletedOwnerNotifications []models.ProductOwnerNotification
-		err = db.Select(&deletedOwnerNotifications, ownerQuery, userID)
 		if err != nil {
-			return c.Status(500).SendString(err.Error())
+			return apperr.Internal("Failed to delete notification", err)
 		}
 
-		likeQuery := `DELETE FROM like_notifications WHERE target_user_id = $1 AND read = true RETURNING *`
-		var deletedLikeNotifications []models.LikeNotification
-		err = db.Select(&deletedLikeNotifications, likeQuery, userID)
-		if err != nil {
-			return c.Status(500).SendString(err.Error())
+		counts := &models.NotificationCounts{}
+		switch kind {
+		case models.KindUser:
+			counts.User = 1
+		case models.KindOwner:
+			counts.Owner = 1
+		case models.KindLike:
+			counts.Like = 1
+		case models.KindSystem:
+			counts.System = 1
 		}
+		counts.Sum()
+		publishDeleted(store, hub, userID, []deletedNotification{{ID: notificationID, Type: kind}}, counts)
 
-		return c.JSON(fiber.Map{
-			"deleted_user_notifications":  len(deletedUserNotifications),
-			"deleted_owner_notifications": len(deletedOwnerNotifications),
-			"deleted_like_notifications":  len(deletedLikeNotifications),
-			"user_notifications":          deletedUserNotifications,
-			"owner_notifications":         deletedOwnerNotifications,
-			"like_notifications":          deletedLikeNotifications,
-		})
+		return c.JSON(deletedNotification{ID: notificationID, Type: kind, Deleted: true})
+	}
+}
+
+// publishDeleted tells the user's streams which notifications are gone with a
+// single notification_deleted event, then refreshes their unread counts
+func publishDeleted(store database.Store, hub *sse.SSEHub, userID string, removed []deletedNotification, counts *models.NotificationCounts) {
+	deletedMessage := map[string]interface{}{
+		"notifications": removed,
+		"deleted":       counts,
+		"timestamp":     time.Now().Format(time.RFC3339),
 	}
+	hub.BroadcastToUser(userID, "notification_deleted", "", deletedMessage)
+	publishUnreadCount(store, hub, userID, "")
 }
 
 // MarkNotificationAsRead marks a notification as read
-func MarkNotificationAsRead(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
+func MarkNotificationAsRead(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		notificationID := c.Params("id")
 		notificationType := c.Query("type")
+		userID := c.Query("user_id")
 
 		if notificationID == "" {
-			return c.Status(400).SendString("Notification ID is required")
+			return apperr.BadRequest(apperr.CodeMissingParameter, "Notification ID is required").WithField("id")
 		}
 
+		// Without a type, resolve the notification by ID alone
 		if notificationType == "" {
-			return c.Status(400).SendString("Notification type is required")
+			item, err := store.FindNotification(notificationID, userID)
+			if err == database.ErrNotFound {
+				return apperr.NotificationNotFound()
+			}
+			if err != nil {
+				return apperr.Internal("Failed to update notification", err)
+			}
+			notificationType = item.Kind
+		}
+
+		// System notifications are shared, so the reader has to say who they are
+		if notificationType == models.KindSystem && userID == "" {
+			return apperr.BadRequest(apperr.CodeMissingParameter, "user_id query parameter is required for system notifications").WithField("user_id")
 		}
 
 		fmt.Printf("%s - %s", notificationID, notificationType)
-		var query string
-		var result sql.Result
-		var err error
 
 		switch notificationType {
-		case "user":
-			query = "UPDATE user_notifications SET read = true WHERE id = $1"
-		case "owner":
-			query = "UPDATE product_owner_notifications SET read = true WHERE id = $1"
-		case "like":
-			query = "UPDATE like_notifications SET read = true WHERE id = $1"
-		case "system":
-			query = "UPDATE system_notifications SET read = true WHERE id = $1"
+		case models.KindUser, models.KindOwner, models.KindLike, models.KindSystem:
 		default:
-			return c.Status(400).SendString("Invalid notification type")
+			return apperr.BadRequest(apperr.CodeInvalidNotificationType, "Invalid notification type").WithField("type")
 		}
 
-		result, err = db.Exec(query, notificationID)
+		// The store returns the user the notification belongs to so we can
+		// broadcast properly. System reads are per user, so only the reader's
+		// own streams are told.
+		ownerID, err := store.MarkRead(notificationType, notificationID, userID)
+		if err == database.ErrNotFound {
+			return apperr.NotificationNotFound()
+		}
 		if err != nil {
-			log.Printf("Error updating notification: %v", err)
-			return c.Status(500).SendString("Failed to update notification")
+			return apperr.Internal("Failed to update notification", err)
+		}
+
+		// Broadcast read status to SSE clients
+		readMessage := map[string]interface{}{
+			"notification_id": notificationID,
+			"type":            notificationType,
+			"read":            true,
+			"timestamp":       time.Now().Format(time.RFC3339),
 		}
+		hub.BroadcastToUser(ownerID, "notification_read", notificationType, readMessage)
+		publishUnreadCount(store, hub, ownerID, notificationType)
 
-		rowsAffected, err := result.RowsAffected()
+		return c.SendString("Notification marked as read")
+	}
+}
+
+// GetNotification returns one notification as an inbox item, whatever its kind.
+// With user_id only that user's notifications match and system read state is theirs.
+func GetNotification(store database.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		item, err := store.FindNotification(c.Params("id"), c.Query("user_id"))
+		if err == database.ErrNotFound {
+			return apperr.NotificationNotFound()
+		}
+		if err != nil {
+			return apperr.Internal("Failed to get notification", err)
+		}
+		return c.JSON(item)
+	}
+}
+
+// maxReadBatch caps the number of IDs MarkNotificationsAsRead accepts
+const maxReadBatch = 500
+
+// markReadRequest is the body of MarkNotificationsAsRead
+type markReadRequest struct {
+	UserID string   `json:"user_id" validate:"required,max=255"`
+	IDs    []string `json:"ids" validate:"required,dive,required,max=255"`
+}
+
+// MarkNotificationsAsRead marks a list of the user's notifications as read,
+// whatever their kind, and returns updated, already_read or not_found per ID
+func MarkNotificationsAsRead(store database.Store, hub *sse.SSEHub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		request := new(markReadRequest)
+		errs, err := decodeBody(c.Body(), request)
 		if err != nil {
-			log.Printf("Error getting rows affected: %v", err)
-			return c.Status(500).SendString("Failed to get update result")
+			return err
+		}
+		if len(request.IDs) > maxReadBatch {
+			return apperr.BadRequest(apperr.CodeBatchTooLarge, fmt.Sprintf("ids can list at most %d notifications", maxReadBatch)).WithField("ids")
+		}
+		if err := validationError(append(errs, validate.Struct(request)...)); err != nil {
+			return err
 		}
 
-		if rowsAffected == 0 {
-			return c.Status(404).SendString("Notification not found")
+		results, err := store.MarkReadByIDs(request.UserID, request.IDs)
+		if err != nil {
+			return apperr.Internal("Failed to update notifications", err)
 		}
 
-		// Broadcast read status to SSE clients
-		readMessage := map[string]interface{}{
-			"notification_id": notificationID,
-			"type":           notificationType,
-			"read":           true,
-			"timestamp":      time.Now().Format(time.RFC3339),
+		counts := &models.NotificationCounts{}
+		updatedIDs := []string{}
+		for _, result := range results {
+			if result.Status != database.ReadUpdated {
+				continue
+			}
+			updatedIDs = append(updatedIDs, result.ID)
+			switch result.Kind {
+			case models.KindUser:
+				counts.User++
+			case models.KindOwner:
+				counts.Owner++
+			case models.KindLike:
+				counts.Like++
+			case models.KindSystem:
+				counts.System++
+			}
 		}
+		counts.Sum()
 
-		// We need to get the user ID for this notification to broadcast properly
-		var userID string
-		if notificationType == "user" {
-			err = db.Get(&userID, "SELECT parent_user_id FROM user_notifications WHERE id = $1", notificationID)
-		} else if notificationType == "owner" {
-			err = db.Get(&userID, "SELECT owner_id FROM product_owner_notifications WHERE id = $1", notificationID)
-		} else if notificationType == "like" {
-			err = db.Get(&userID, "SELECT target_user_id FROM like_notifications WHERE id = $1", notificationID)
-		} else if notificationType == "system" {
-			// For system notifications, we need to broadcast to all affected users
-			// For now, we'll skip the individual user broadcast since system notifications
-			// can target multiple users or be broadcasts
-			userID = "" // Will skip the broadcast below
+		if counts.Total > 0 {
+			bulkMessage := map[string]interface{}{
+				"updated":   counts,
+				"ids":       updatedIDs,
+				"timestamp": time.Now().Format(time.RFC3339),
+			}
+			hub.BroadcastToUser(request.UserID, "notifications_read_bulk", "", bulkMessage)
+			publishUnreadCount(store, hub, request.UserID, "")
 		}
 
-		if err == nil {
-			hub.BroadcastToUser(userID, "notification_read", notificationType, readMessage)
+		return c.JSON(markReadResult{Results: results, Updated: counts})
+	}
+}
+
+// MarkAllNotificationsAsRead marks every unread notification of a user as read
+// in one go. Optional filters:
+//
+//	type        comma-separated kinds (user, owner, like, system)
+//	product_id  only notifications about this product
+//	before      RFC3339 time; only notifications created before it
+//
+// The user's streams get a single notifications_read_bulk event rather than
+// one notification_read per notification.
+func MarkAllNotificationsAsRead(store database.Store, hub *sse.SSEHub) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Query("user_id")
+		if userID == "" {
+			return apperr.MissingParameter("user_id")
 		}
 
-		return c.SendString("Notification marked as read")
+		var q database.NotificationQuery
+		var err error
+		if q.Kinds, err = parseKinds(c); err != nil {
+			return err
+		}
+		q.ProductID = c.Query("product_id")
+		if q.Until, err = parseTimeParam(c, "before"); err != nil {
+			return err
+		}
+
+		counts, err := store.MarkAllRead(userID, q)
+		if err != nil {
+			return apperr.Internal("Failed to update notifications", err)
+		}
+
+		if counts.Total > 0 {
+			bulkMessage := map[string]interface{}{
+				"updated":    counts,
+				"types":      q.Kinds,
+				"product_id": q.ProductID,
+				"before":     q.Until,
+				"timestamp":  time.Now().Format(time.RFC3339),
+			}
+			hub.BroadcastToUser(userID, "notifications_read_bulk", "", bulkMessage)
+			publishUnreadCount(store, hub, userID, "")
+		}
+
+		return c.JSON(markAllReadResult{Updated: counts})
 	}
-}
\ No newline at end of file
+}
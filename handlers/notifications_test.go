@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/sse"
+)
+
+// send makes a JSON request to app and returns the status and decoded body
+func send(t *testing.T, app *fiber.App, method string, path string, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(res.Body)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("%s %s: response is not a JSON object: %s", method, path, raw)
+	}
+	return res.StatusCode, decoded
+}
+
+// createUsers adds users to the store behind app
+func createUsers(t *testing.T, app *fiber.App, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if status, body := send(t, app, fiber.MethodPost, "/v1/users", `{"id":"`+id+`"}`); status >= 300 {
+			t.Fatalf("creating user %s: %d %v", id, status, body)
+		}
+	}
+}
+
+func TestCreateCommentNotification(t *testing.T) {
+	store := database.NewMemoryStore()
+	app, _ := newTestAPIWithStore(store)
+	body := `{"id":"n1","parent_user_id":"user_b","from_id":"user_a","content":"Nice review"}`
+
+	status, res := send(t, app, fiber.MethodPost, "/v1/notifications/comment", body)
+	if status != fiber.StatusBadRequest || res["code"] != "USER_NOT_FOUND" {
+		t.Fatalf("unknown recipient: got %d %v, want 400 USER_NOT_FOUND", status, res)
+	}
+
+	createUsers(t, app, "user_a", "user_b")
+	status, res = send(t, app, fiber.MethodPost, "/v1/notifications/comment", body)
+	if status != fiber.StatusCreated || res["notification_type"] != "comment" {
+		t.Fatalf("got %d %v, want 201 with notification_type comment", status, res)
+	}
+
+	set, err := store.ListNotifications("user_b", database.Unread())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.User) != 1 || set.User[0].ID != "n1" {
+		t.Errorf("user_b's unread notifications = %+v, want n1", set.User)
+	}
+}
+
+func TestCreateLikeNotificationSelfLike(t *testing.T) {
+	store := database.NewMemoryStore()
+	app, _ := newTestAPIWithStore(store)
+	createUsers(t, app, "user_a")
+
+	status, res := send(t, app, fiber.MethodPost, "/v1/notifications/like",
+		`{"target_type":"review","target_id":"user_a","from_id":"user_a"}`)
+	if status != fiber.StatusOK || res["skipped"] != true || res["reason"] == "" {
+		t.Fatalf("self-like: got %d %v, want 200 with skipped and a reason", status, res)
+	}
+
+	set, err := store.ListNotifications("user_a", database.Unread())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Like) != 0 {
+		t.Errorf("self-like stored %d like notifications", len(set.Like))
+	}
+}
+
+func TestCreateSystemNotificationValidatesID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"empty", ""},
+		{"too long", strings.Repeat("x", 256)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestAPI()
+			status, res := send(t, app, fiber.MethodPost, "/v1/notifications/system",
+				`{"id":"`+tt.id+`","title":"Maintenance","message":"Down at noon"}`)
+			if status != fiber.StatusBadRequest || res["code"] != "VALIDATION_FAILED" || res["field"] != "id" {
+				t.Errorf("got %d %v, want 400 VALIDATION_FAILED for id", status, res)
+			}
+		})
+	}
+}
+
+func TestCreateSystemNotificationReach(t *testing.T) {
+	config := sse.DefaultConfig()
+	config.ClientBuffer = 1
+	hub := sse.NewSSEHubWithConfig(config)
+	go hub.Run()
+	defer hub.Close()
+	app, _ := newTestAPIWithHub(database.NewMemoryStore(), hub)
+	createUsers(t, app, "user_a", "user_b")
+
+	// user_b's stream is too far behind to take the broadcast
+	hub.RegisterClient(hub.NewClient("user_a", sse.FormatEvents))
+	behind := hub.NewClient("user_b", sse.FormatEvents)
+	hub.RegisterClient(behind)
+	behind.Send([]byte("frame"))
+
+	tests := []struct {
+		name      string
+		body      string
+		wantReach map[string]interface{}
+	}{
+		{"broadcast", `{"id":"s1","title":"Maintenance","message":"Down at noon"}`,
+			map[string]interface{}{"users": 2.0, "clients": 1.0, "dropped": 1.0}},
+		{"targeted", `{"id":"s2","title":"Welcome","message":"Hi","target_user_ids":["user_a"]}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, res := send(t, app, fiber.MethodPost, "/v1/notifications/system", tt.body)
+			if status != fiber.StatusCreated || res["id"] == nil {
+				t.Fatalf("got %d %v, want 201 with the notification", status, res)
+			}
+			reach, _ := res["reach"].(map[string]interface{})
+			if !reflect.DeepEqual(reach, tt.wantReach) {
+				t.Errorf("reach = %v, want %v", res["reach"], tt.wantReach)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/models"
+	"github.com/ktappdev/noti-service/reviewit"
+	"github.com/ktappdev/noti-service/sse"
+	"github.com/ktappdev/noti-service/validate"
+	"github.com/lib/pq"
+)
+
+// The prepare functions validate a new notification and fill in the fields
+// derived from it, such as its type and recipient. They are shared by the
+// single create endpoints and the batch endpoint, and return *apperr.Error
+// values carrying the status and code to respond with. errs holds the field
+// errors already found while decoding the request; they are reported together
+// with the notification's own validation errors before any lookups are made.
+
+// errSelfLike is returned by prepareLikeNotification when users like their own content
+var errSelfLike = errors.New("No notification created for self-like")
+
+// prepareOwnerNotification validates a product owner notification
+func prepareOwnerNotification(store database.Store, n *models.ProductOwnerNotification, errs []apperr.FieldError) error {
+	n.NotificationType = "review"
+
+	if err := validationError(append(errs, validate.Struct(n)...)); err != nil {
+		return err
+	}
+
+	exists, err := store.UserExists(n.OwnerID)
+	if err != nil {
+		return apperr.Internal("Failed to check owner", err)
+	}
+	if !exists {
+		log.Println("owner does not exist")
+		return apperr.UserNotFound("owner_id", n.OwnerID)
+	}
+	return nil
+}
+
+// prepareCommentNotification validates a notification for a comment on a review
+func prepareCommentNotification(store database.Store, n *models.UserNotification, errs []apperr.FieldError) error {
+	n.NotificationType = "comment"
+
+	errs = append(errs, validate.Struct(n)...)
+	// For comments on reviews, target_user_id should be provided directly
+	if n.ParentUserID == "" {
+		errs = append(errs, apperr.FieldError{Field: "parent_user_id", Rule: "required",
+			Message: "target_user_id (parent_user_id) is required for comment notifications"})
+	}
+	if err := validationError(errs); err != nil {
+		return err
+	}
+	return requireSenderAndRecipient(store, "parent_user_id", n.ParentUserID, n.FromID)
+}
+
+// prepareReplyNotification validates a notification for a reply to a comment,
+// looking the recipient up from the parent comment when it isn't given
+func prepareReplyNotification(store database.Store, n *models.UserNotification, errs []apperr.FieldError) error {
+	n.NotificationType = "reply"
+
+	errs = append(errs, validate.Struct(n)...)
+	if n.ParentUserID == "" && n.ParentID == "" {
+		errs = append(errs, apperr.FieldError{Field: "parent_id", Rule: "required",
+			Message: "Either parent_user_id or parent_id (comment ID) is required for reply notifications"})
+	}
+	if err := validationError(errs); err != nil {
+		return err
+	}
+
+	if n.ParentUserID == "" {
+		// ParentID should be a comment ID, look up the user who made that comment
+		parentUserID, err := reviewit.GetParentCommentUserID(n.ParentID)
+		if err != nil {
+			log.Printf("ERROR getting parent user ID from comment %s: %v", n.ParentID, err)
+			return reviewitError("parent_id", "Parent comment "+n.ParentID, err)
+		}
+		n.ParentUserID = parentUserID
+		log.Printf("Looked up user ID from comment %s: %s", n.ParentID, parentUserID)
+	}
+	return requireSenderAndRecipient(store, "parent_user_id", n.ParentUserID, n.FromID)
+}
+
+// prepareSystemNotification validates a system notification and cleans up its
+// target list. No targets means a broadcast to every user.
+func prepareSystemNotification(store database.Store, n *models.SystemNotification, errs []apperr.FieldError) error {
+	n.NotificationType = "system"
+
+	if err := validationError(append(errs, validate.Struct(n)...)); err != nil {
+		return err
+	}
+
+	// Drop blanks and duplicates so each recipient gets exactly one row
+	seen := make(map[string]bool, len(n.TargetUserIDs))
+	targetUserIDs := make(pq.StringArray, 0, len(n.TargetUserIDs))
+	for _, userID := range n.TargetUserIDs {
+		userID = strings.TrimSpace(userID)
+		if userID != "" && !seen[userID] {
+			seen[userID] = true
+			targetUserIDs = append(targetUserIDs, userID)
+		}
+	}
+	n.TargetUserIDs = targetUserIDs
+
+	for _, userID := range n.TargetUserIDs {
+		exists, err := store.UserExists(userID)
+		if err != nil {
+			return apperr.Internal("Failed to check target users", err)
+		}
+		if !exists {
+			log.Printf("Target user %s does not exist", userID)
+			return apperr.UserNotFound("target_user_ids", userID)
+		}
+	}
+
+	// Read state is tracked per recipient, so a new notification is unread for everyone
+	n.Read = false
+	return nil
+}
+
+// prepareLikeNotification validates a like notification and resolves the
+// author of the liked comment or review. It returns errSelfLike when users
+// like their own content.
+func prepareLikeNotification(store database.Store, n *models.LikeNotification, errs []apperr.FieldError) error {
+	if err := validationError(append(errs, validate.Struct(n)...)); err != nil {
+		return err
+	}
+
+	// TargetID is either the author's user ID already or the ID of the
+	// comment/review, whose author is looked up in ReviewIt
+	if len(n.TargetID) > 5 && n.TargetID[:5] == "user_" {
+		n.TargetUserID = n.TargetID
+		log.Printf("Using TargetID as user ID directly for %s: %s", n.TargetType, n.TargetUserID)
+	} else if n.TargetType == "comment" {
+		targetUserID, err := reviewit.GetCommentUserID(n.TargetID)
+		if err != nil {
+			log.Printf("ERROR getting comment user ID: %v", err)
+			return reviewitError("target_id", "Comment "+n.TargetID, err)
+		}
+		n.TargetUserID = targetUserID
+		log.Printf("Looked up user ID from comment: %s", targetUserID)
+	} else {
+		targetUserID, err := reviewit.GetReviewUserID(n.TargetID)
+		if err != nil {
+			log.Printf("ERROR getting review user ID: %v", err)
+			return reviewitError("target_id", "Review "+n.TargetID, err)
+		}
+		n.TargetUserID = targetUserID
+		log.Printf("Looked up user ID from review: %s", targetUserID)
+	}
+
+	if err := requireSenderAndRecipient(store, "target_id", n.TargetUserID, n.FromID); err != nil {
+		return err
+	}
+
+	if n.TargetUserID == n.FromID {
+		return errSelfLike
+	}
+	return nil
+}
+
+// requireSenderAndRecipient checks that both users of a notification exist.
+// targetField names the request field the recipient came from.
+func requireSenderAndRecipient(store database.Store, targetField string, targetUserID string, fromID string) error {
+	exists, err := store.UserExists(targetUserID)
+	if err != nil {
+		return apperr.Internal("Failed to check target user", err)
+	}
+	if !exists {
+		log.Printf("Target user %s does not exist", targetUserID)
+		return apperr.UserNotFound(targetField, targetUserID)
+	}
+
+	exists, err = store.UserExists(fromID)
+	if err != nil {
+		return apperr.Internal("Failed to check from user", err)
+	}
+	if !exists {
+		log.Printf("From user %s does not exist", fromID)
+		return apperr.UserNotFound("from_id", fromID)
+	}
+	return nil
+}
+
+// reviewitError turns a failed ReviewIt lookup of what, read from field, into
+// a response error
+func reviewitError(field string, what string, err error) error {
+	switch {
+	case errors.Is(err, reviewit.ErrNotConfigured):
+		return apperr.New(503, apperr.CodeReviewitUnavailable,
+			"ReviewIt database connection not configured. Please set REVIEWIT_DATABASE_URL environment variable.").Wrap(err)
+	case errors.Is(err, reviewit.ErrNotFound):
+		return apperr.BadRequest(apperr.CodeTargetNotFound, what+" does not exist in ReviewIt").WithField(field)
+	default:
+		return apperr.New(503, apperr.CodeReviewitUnavailable, "ReviewIt lookup failed").Wrap(err)
+	}
+}
+
+// publishNewNotification sends a stored notification to its recipients'
+// streams as a new_notification event, followed by their unread counts. For
+// a broadcast system notification it returns who it reached.
+func publishNewNotification(store database.Store, hub *sse.SSEHub, kind string, notification interface{}) *sse.BroadcastResult {
+	recipients, reach := broadcastNewNotification(hub, kind, notification)
+	publishUnreadCounts(store, hub, recipients, kind)
+	return reach
+}
+
+// broadcastNewNotification sends a new_notification event to the recipients of
+// a stored notification and returns who they are. Broadcast system
+// notifications go to everyone connected, and their reach is returned too.
+func broadcastNewNotification(hub *sse.SSEHub, kind string, notification interface{}) ([]string, *sse.BroadcastResult) {
+	var recipients []string
+	switch n := notification.(type) {
+	case *models.UserNotification:
+		recipients = []string{n.ParentUserID}
+	case *models.ProductOwnerNotification:
+		recipients = []string{n.OwnerID}
+	case *models.LikeNotification:
+		recipients = []string{n.TargetUserID}
+	case *models.SystemNotification:
+		if len(n.TargetUserIDs) == 0 {
+			log.Printf("Broadcasting system notification %s to all users", n.ID)
+			reach := hub.BroadcastToAll("new_notification", kind, n)
+			return reach.UserIDs, &reach
+		}
+		recipients = n.TargetUserIDs
+	}
+
+	for _, userID := range recipients {
+		hub.BroadcastToUser(userID, "new_notification", kind, notification)
+	}
+	return recipients, nil
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
+)
+
+// maxPageSize caps the limit query parameter
+const maxPageSize = 200
+
+// parseNotificationQuery reads the list filters of parseNotificationFilters
+// plus the paging parameters:
+//
+//	limit       page size, up to maxPageSize; omitted means everything
+//	cursor      next_cursor from the previous page
+func parseNotificationQuery(c *fiber.Ctx) (database.NotificationQuery, error) {
+	q, err := parseNotificationFilters(c)
+	if err != nil {
+		return q, err
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 1 || q.Limit > maxPageSize {
+			return q, apperr.InvalidParameter("limit", fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if q.After, err = database.DecodeCursor(cursor); err != nil {
+			return q, apperr.BadRequest(apperr.CodeInvalidCursor, "cursor is not a valid next_cursor value").WithField("cursor")
+		}
+	}
+
+	return q, nil
+}
+
+// parseNotificationFilters reads the filters shared by the list and bulk endpoints:
+//
+//	type        comma-separated kinds (user, owner, like, system)
+//	read        true, false or any
+//	product_id  only notifications about this product
+//	from_id     only notifications sent by this user
+//	since       RFC3339 time, inclusive
+//	until       RFC3339 time, exclusive
+func parseNotificationFilters(c *fiber.Ctx) (database.NotificationQuery, error) {
+	var q database.NotificationQuery
+	var err error
+
+	if q.Kinds, err = parseKinds(c); err != nil {
+		return q, err
+	}
+
+	if read := c.Query("read"); read != "" && read != "any" {
+		value, err := strconv.ParseBool(read)
+		if err != nil {
+			return q, apperr.InvalidParameter("read", "read must be true, false or any")
+		}
+		q.Read = &value
+	}
+
+	q.ProductID = c.Query("product_id")
+	q.FromID = c.Query("from_id")
+
+	if q.Since, err = parseTimeParam(c, "since"); err != nil {
+		return q, err
+	}
+	if q.Until, err = parseTimeParam(c, "until"); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+// parseKinds reads the optional comma-separated type query parameter
+func parseKinds(c *fiber.Ctx) ([]string, error) {
+	types := c.Query("type")
+	if types == "" {
+		return nil, nil
+	}
+	var kinds []string
+	for _, kind := range strings.Split(types, ",") {
+		kind = strings.TrimSpace(kind)
+		if !isKnownKind(kind) {
+			return nil, apperr.BadRequest(apperr.CodeInvalidNotificationType, fmt.Sprintf("Invalid notification type %q", kind)).WithField("type")
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// parseTimeParam parses an optional RFC3339 query parameter
+func parseTimeParam(c *fiber.Ctx, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, apperr.InvalidParameter(name, name+" must be an RFC3339 timestamp")
+	}
+	return &t, nil
+}
+
+// isKnownKind reports whether kind is one of the notification kinds
+func isKnownKind(kind string) bool {
+	for _, k := range database.AllKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeCursor returns the next_cursor value for a page, or nil on the last page
+func encodeCursor(cursor *database.Cursor) *string {
+	if cursor == nil {
+		return nil
+	}
+	encoded := cursor.Encode()
+	return &encoded
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/models"
+)
+
+// Response bodies that aren't a model on their own. They are named types
+// rather than maps so the OpenAPI document can describe them.
+
+// notificationFeed is a page of the merged feed of every notification kind
+type notificationFeed struct {
+	Items []models.InboxItem `json:"items"`
+	// NextCursor fetches the next page; null on the last one
+	NextCursor *string `json:"next_cursor"`
+}
+
+// notificationLists is a page of notifications grouped by kind
+type notificationLists struct {
+	User       []models.UserNotification         `json:"user_notifications"`
+	Owner      []models.ProductOwnerNotification `json:"owner_notifications"`
+	Like       []models.LikeNotification         `json:"like_notifications"`
+	System     []models.SystemNotification       `json:"system_notifications"`
+	NextCursor *string                           `json:"next_cursor"`
+}
+
+// deleteNotificationsResult is the body of DeleteNotifications
+type deleteNotificationsResult struct {
+	Deleted                    *models.NotificationCounts        `json:"deleted"`
+	DeletedUserNotifications   int                               `json:"deleted_user_notifications"`
+	DeletedOwnerNotifications  int                               `json:"deleted_owner_notifications"`
+	DeletedLikeNotifications   int                               `json:"deleted_like_notifications"`
+	DeletedSystemNotifications int                               `json:"deleted_system_notifications"`
+	User                       []models.UserNotification         `json:"user_notifications"`
+	Owner                      []models.ProductOwnerNotification `json:"owner_notifications"`
+	Like                       []models.LikeNotification         `json:"like_notifications"`
+	System                     []models.SystemNotification       `json:"system_notifications"`
+}
+
+// deletedNotification is the body of DeleteNotification, and one entry of
+// the notification_deleted event
+type deletedNotification struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// systemNotificationResult is the body of CreateSystemNotification: the
+// stored notification, plus its reach when it was broadcast to everyone
+type systemNotificationResult struct {
+	*models.SystemNotification
+	Reach *broadcastReach `json:"reach,omitempty"`
+}
+
+// broadcastReach is who a broadcast to everyone was delivered to on the
+// instance that sent it; other instances deliver it to their own streams
+type broadcastReach struct {
+	Users   int `json:"users"`
+	Clients int `json:"clients"`
+	// Dropped counts streams too far behind to take it, which are sent
+	// resync_required instead
+	Dropped int `json:"dropped"`
+}
+
+// skippedNotification is the body of a create request that deliberately
+// stored nothing, e.g. a self-like
+type skippedNotification struct {
+	Skipped bool   `json:"skipped"`
+	Reason  string `json:"reason"`
+}
+
+// markReadResult is the body of MarkNotificationsAsRead
+type markReadResult struct {
+	Results []database.ReadResult      `json:"results"`
+	Updated *models.NotificationCounts `json:"updated"`
+}
+
+// markAllReadResult is the body of MarkAllNotificationsAsRead
+type markAllReadResult struct {
+	Updated *models.NotificationCounts `json:"updated"`
+}
+
+// batchResponse is the body of CreateNotificationBatch
+type batchResponse struct {
+	Mode    string        `json:"mode"`
+	Created int           `json:"created"`
+	Results []batchResult `json:"results"`
+}
+
+// newNotificationFeed returns a page of the merged feed
+func newNotificationFeed(set *database.NotificationSet) notificationFeed {
+	return notificationFeed{Items: set.InboxItems(), NextCursor: encodeCursor(set.NextCursor)}
+}
+
+// newNotificationLists returns a page of notifications grouped by kind
+func newNotificationLists(set *database.NotificationSet) notificationLists {
+	return notificationLists{
+		User:       set.User,
+		Owner:      set.Owner,
+		Like:       set.Like,
+		System:     set.System,
+		NextCursor: encodeCursor(set.NextCursor),
+	}
+}
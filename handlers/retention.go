@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/retention"
+)
+
+// RunRetention runs the retention rules now. Pass dry_run=true to get the
+// report without removing anything. A worker configured for dry runs only
+// ever dry runs; dry_run=false doesn't override it.
+func RunRetention(worker *retention.Worker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report := worker.RunOnce(worker.DryRun() || c.QueryBool("dry_run", false))
+		return c.JSON(report)
+	}
+}
+
+// GetRetentionReport returns the report from the most recent retention run
+func GetRetentionReport(worker *retention.Worker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report := worker.LastReport()
+		if report == nil {
+			return apperr.NotFound(apperr.CodeNotFound, "Retention has not run yet")
+		}
+		return c.JSON(report)
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/retention"
+)
+
+func TestRunRetentionKeepsConfiguredDryRun(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	worker := retention.NewWorker(database.NewMemoryStore(), retention.Config{DryRun: true})
+	app.Post("/run", RunRetention(worker))
+
+	for _, path := range []string{"/run", "/run?dry_run=false"} {
+		status, report := send(t, app, fiber.MethodPost, path, "")
+		if status != fiber.StatusOK || report["dry_run"] != true {
+			t.Errorf("POST %s with RETENTION_DRY_RUN: got %d dry_run=%v, want a dry run", path, status, report["dry_run"])
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/models"
+	"github.com/ktappdev/noti-service/openapi"
+	"github.com/ktappdev/noti-service/retention"
+	"github.com/ktappdev/noti-service/sse"
+)
+
+// Route tags, which group operations in the docs
+const (
+	tagUsers  = "Users"
+	tagCreate = "Create notifications"
+	tagRead   = "Read notifications"
+	tagUpdate = "Update notifications"
+	tagStream = "Stream"
+	tagAdmin  = "Admin"
+)
+
+// Parameters shared by several routes
+var (
+	userIDParam = openapi.RequiredQuery("user_id", "The user whose notifications to use")
+
+	// filterParams are read by parseNotificationFilters
+	filterParams = []openapi.Param{
+		openapi.Query("type", "Comma-separated notification kinds: user, owner, like, system"),
+		{Name: "read", Description: "Read state to match", Enum: []string{"true", "false", "any"}},
+		openapi.Query("product_id", "Only notifications about this product"),
+		openapi.Query("from_id", "Only notifications sent by this user"),
+		openapi.Query("since", "RFC3339 time; only notifications created at or after it"),
+		openapi.Query("until", "RFC3339 time; only notifications created before it"),
+	}
+
+	// pageParams are read by parseNotificationQuery on top of filterParams
+	pageParams = []openapi.Param{
+		{Name: "limit", Type: "integer", Description: "Page size, up to 200; omitted returns everything"},
+		openapi.Query("cursor", "next_cursor of the previous page"),
+	}
+
+	idempotencyParam = openapi.Header(IdempotencyKeyHeader,
+		"Makes the request safe to retry: repeats within the key's TTL get the first response back. A dedupe_key body field works too.")
+)
+
+// When the unversioned aliases were deprecated, with the /v1 release, and
+// when they are due to be removed, six months later
+var (
+	legacyRoutesDeprecated = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+	legacyRoutesSunset     = time.Date(2027, time.April, 16, 0, 0, 0, 0, time.UTC)
+)
+
+// LegacyRoutesFromEnv configures the deprecated unversioned aliases of the
+// /v1 routes. It returns nil, dropping the aliases, when LEGACY_ROUTES is
+// "false". LEGACY_ROUTES_DEPRECATED and LEGACY_ROUTES_SUNSET are RFC3339
+// times sent in the Deprecation and Sunset headers, overriding the release
+// dates above, and LEGACY_ROUTES_LINK is a migration guide URL.
+func LegacyRoutesFromEnv() *openapi.Legacy {
+	if os.Getenv("LEGACY_ROUTES") == "false" {
+		log.Printf("Legacy unversioned routes are disabled")
+		return nil
+	}
+	return &openapi.Legacy{
+		Deprecated: envTime("LEGACY_ROUTES_DEPRECATED", legacyRoutesDeprecated),
+		Sunset:     envTime("LEGACY_ROUTES_SUNSET", legacyRoutesSunset),
+		Link:       os.Getenv("LEGACY_ROUTES_LINK"),
+	}
+}
+
+// envTime reads an optional RFC3339 time from the environment, returning
+// fallback when it is unset or invalid
+func envTime(name string, fallback time.Time) time.Time {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		log.Printf("Invalid %s %q, expected an RFC3339 time; using %s", name, v, fallback.Format(time.RFC3339))
+		return fallback
+	}
+	return t
+}
+
+// params joins parameter lists
+func params(lists ...[]openapi.Param) []openapi.Param {
+	var all []openapi.Param
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// RegisterRoutes adds every API route to the registry, which mounts and
+// documents them. Routes must be added here rather than on the app directly
+// so the OpenAPI document stays complete.
+//
+// The API lives under /v1. Its routes are also served at their original
+// unversioned paths as deprecated aliases, until LEGACY_ROUTES=false turns
+// them off; see LegacyRoutesFromEnv.
+func RegisterRoutes(api *openapi.Registry, store database.Store, hub *sse.SSEHub, worker *retention.Worker) {
+	api.AddTag(tagUsers, "Users must exist here before they can send or receive notifications")
+	api.AddTag(tagCreate, "Create routes accept an Idempotency-Key header so producers can retry safely")
+	api.AddTag(tagRead, "List, count and fetch notifications")
+	api.AddTag(tagUpdate, "Mark notifications read and delete them")
+	api.AddTag(tagStream, "Server-sent events; see /v1/sse-help for the event formats")
+	api.AddTag(tagAdmin, "Operational endpoints")
+	api.AddTag(openapi.DocsTag, "This documentation")
+
+	v1 := api.Group("/v1", LegacyRoutesFromEnv())
+
+	// Create routes accept an Idempotency-Key header so producers can retry safely
+	idempotent := Idempotency(store, IdempotencyTTLFromEnv())
+
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodPost,
+		Path:        "/users",
+		Summary:     "Create or update a user",
+		Description: "Idempotent: posting an existing user updates their names. username and full_name default to values derived from id.",
+		Tags:        []string{tagUsers},
+		Params:      []openapi.Param{idempotencyParam},
+		Body:        models.User{},
+		Responses:   []openapi.Reply{{Description: "The saved user", Body: models.User{}}},
+		Handlers:    []fiber.Handler{idempotent, CreateUser(store)},
+	})
+
+	// Notification creation
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodPost,
+		Path:      "/notifications/product-owner",
+		Summary:   "Notify a product owner about a review",
+		Tags:      []string{tagCreate},
+		Params:    []openapi.Param{idempotencyParam},
+		Body:      models.ProductOwnerNotification{},
+		Responses: []openapi.Reply{{Status: fiber.StatusCreated, Description: "The stored notification", Body: models.ProductOwnerNotification{}}},
+		Handlers:  []fiber.Handler{idempotent, CreateProductOwnerNotification(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodPost,
+		Path:        "/notifications/comment",
+		Summary:     "Notify a review author about a comment",
+		Description: "parent_user_id, the review author, is required.",
+		Tags:        []string{tagCreate},
+		Params:      []openapi.Param{idempotencyParam},
+		Body:        models.UserNotification{},
+		Responses:   []openapi.Reply{{Status: fiber.StatusCreated, Description: "The stored notification", Body: models.UserNotification{}}},
+		Handlers:    []fiber.Handler{idempotent, CreateCommentNotification(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodPost,
+		Path:        "/notifications/reply",
+		Summary:     "Notify a comment author about a reply",
+		Description: "Give parent_user_id, or parent_id (the comment replied to) to look its author up in ReviewIt.",
+		Tags:        []string{tagCreate},
+		Params:      []openapi.Param{idempotencyParam},
+		Body:        models.UserNotification{},
+		Responses:   []openapi.Reply{{Status: fiber.StatusCreated, Description: "The stored notification", Body: models.UserNotification{}}},
+		Handlers:    []fiber.Handler{idempotent, CreateReplyNotification(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodPost,
+		Path:        "/notifications/like",
+		Summary:     "Notify a content author about a like",
+		Description: "target_id is the liked comment or review, whose author is looked up in ReviewIt, or a user ID starting with user_. Liking your own content creates nothing.",
+		Tags:        []string{tagCreate},
+		Params:      []openapi.Param{idempotencyParam},
+		Body:        models.LikeNotification{},
+		Responses: []openapi.Reply{
+			{Status: fiber.StatusCreated, Description: "The stored notification", Body: models.LikeNotification{}},
+			{Status: fiber.StatusOK, Description: "Self-like; no notification was created", Body: skippedNotification{}},
+		},
+		Handlers: []fiber.Handler{idempotent, CreateLikeNotification(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodPost,
+		Path:        "/notifications/system",
+		Summary:     "Send a system notification",
+		Description: "Goes to target_user_ids, or to every user when that is empty.",
+		Tags:        []string{tagCreate},
+		Params:      []openapi.Param{idempotencyParam},
+		Body:        models.SystemNotification{},
+		Responses:   []openapi.Reply{{Status: fiber.StatusCreated, Description: "The stored notification, with its reach on this instance when broadcast to everyone", Body: systemNotificationResult{}}},
+		Handlers:    []fiber.Handler{idempotent, CreateSystemNotification(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:  fiber.MethodPost,
+		Path:    "/notifications/batch",
+		Summary: "Create notifications of mixed types",
+		Description: "Each item is the body of a create route plus a type of comment, reply, owner, like or system, up to 100 items. " +
+			"atomic mode stores all items or none; partial mode stores the valid ones and answers 207 if any failed.",
+		Tags:   []string{tagCreate},
+		Params: []openapi.Param{idempotencyParam},
+		Body:   batchRequest{},
+		Responses: []openapi.Reply{
+			{Status: fiber.StatusCreated, Description: "Every item was created", Body: batchResponse{}},
+			{Status: fiber.StatusMultiStatus, Description: "Partial mode; some items weren't created", Body: batchResponse{}},
+		},
+		Handlers: []fiber.Handler{idempotent, CreateNotificationBatch(store, hub)},
+	})
+
+	// Reading
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodGet,
+		Path:      "/notifications/latest",
+		Summary:   "Get the newest notifications across every kind",
+		Tags:      []string{tagRead},
+		Params:    params([]openapi.Param{userIDParam, {Name: "limit", Type: "integer", Description: "How many, up to 200; defaults to 10"}}, filterParams),
+		Responses: []openapi.Reply{{Description: "Newest first; next_cursor continues in /v1/inbox", Body: notificationFeed{}}},
+		Handlers:  []fiber.Handler{GetLatestNotifications(store)},
+	})
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodGet,
+		Path:      "/notifications",
+		Summary:   "List notifications grouped by kind",
+		Tags:      []string{tagRead},
+		Params:    params([]openapi.Param{userIDParam}, filterParams, pageParams),
+		Responses: []openapi.Reply{{Description: "Notifications of each kind, newest first", Body: notificationLists{}}},
+		Handlers:  []fiber.Handler{GetAllNotifications(store)},
+	})
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodGet,
+		Path:      "/notifications/unread",
+		Summary:   "List unread notifications grouped by kind",
+		Tags:      []string{tagRead},
+		Params:    params([]openapi.Param{userIDParam}, filterParams, pageParams),
+		Responses: []openapi.Reply{{Description: "Unread notifications of each kind, newest first; read is ignored", Body: notificationLists{}}},
+		Handlers:  []fiber.Handler{GetAllUnreadNotifications(store)},
+	})
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodGet,
+		Path:      "/notifications/counts",
+		Summary:   "Count unread notifications",
+		Tags:      []string{tagRead},
+		Params:    []openapi.Param{userIDParam},
+		Responses: []openapi.Reply{{Description: "Unread totals per kind and overall", Body: models.NotificationCounts{}}},
+		Handlers:  []fiber.Handler{GetUnreadCounts(store)},
+	})
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodGet,
+		Path:      "/inbox",
+		Summary:   "Page through every notification kind as one feed",
+		Tags:      []string{tagRead},
+		Params:    params([]openapi.Param{userIDParam}, filterParams, pageParams),
+		Responses: []openapi.Reply{{Description: "Newest first", Body: notificationFeed{}}},
+		Handlers:  []fiber.Handler{GetInbox(store)},
+		NoAlias:   true,
+	})
+
+	// Updating
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodDelete,
+		Path:        "/notifications",
+		Summary:     "Delete notifications matching filters",
+		Description: "read defaults to true, so with no filters every read notification is deleted; pass read=any to include unread ones. System notifications are dismissed for the user instead.",
+		Tags:        []string{tagUpdate},
+		Params:      params([]openapi.Param{userIDParam}, filterParams),
+		Responses:   []openapi.Reply{{Description: "What was deleted", Body: deleteNotificationsResult{}}},
+		Handlers:    []fiber.Handler{DeleteNotifications(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodPost,
+		Path:        "/notifications/read",
+		Summary:     "Mark notifications read by ID",
+		Description: "Up to 500 IDs of any kind.",
+		Tags:        []string{tagUpdate},
+		Body:        markReadRequest{},
+		Responses:   []openapi.Reply{{Description: "updated, already_read or not_found per ID", Body: markReadResult{}}},
+		Handlers:    []fiber.Handler{MarkNotificationsAsRead(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:  fiber.MethodPut,
+		Path:    "/notifications/read-all",
+		Summary: "Mark every unread notification read",
+		Tags:    []string{tagUpdate},
+		Params: []openapi.Param{
+			userIDParam,
+			filterParams[0],
+			filterParams[2],
+			openapi.Query("before", "RFC3339 time; only notifications created before it"),
+		},
+		Responses: []openapi.Reply{{Description: "How many notifications of each kind were updated", Body: markAllReadResult{}}},
+		Handlers:  []fiber.Handler{MarkAllNotificationsAsRead(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:  fiber.MethodPut,
+		Path:    "/notifications/:id/read",
+		Summary: "Mark one notification read",
+		Tags:    []string{tagUpdate},
+		Params: []openapi.Param{
+			{Name: "type", Description: "The notification's kind; looked up when omitted", Enum: database.AllKinds},
+			openapi.Query("user_id", "The reader; required for system notifications"),
+		},
+		Responses: []openapi.Reply{{Description: "Marked read", ContentType: fiber.MIMETextPlainCharsetUTF8}},
+		Handlers:  []fiber.Handler{MarkNotificationAsRead(store, hub)},
+	})
+
+	// Retention admin routes
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodPost,
+		Path:      "/admin/retention/run",
+		Summary:   "Apply the retention rules now",
+		Tags:      []string{tagAdmin},
+		Params:    []openapi.Param{{Name: "dry_run", Type: "boolean", Description: "Report what would be removed without removing it. Always on when RETENTION_DRY_RUN is set; false can't turn it off"}},
+		Responses: []openapi.Reply{{Description: "The run's report", Body: retention.Report{}}},
+		Handlers:  []fiber.Handler{RunRetention(worker)},
+	})
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodGet,
+		Path:      "/admin/retention/report",
+		Summary:   "Get the last retention report",
+		Tags:      []string{tagAdmin},
+		Responses: []openapi.Reply{{Description: "The most recent run's report", Body: retention.Report{}}},
+		Handlers:  []fiber.Handler{GetRetentionReport(worker)},
+	})
+
+	// SSE routes
+	v1.Add(openapi.Route{
+		Method:  fiber.MethodGet,
+		Path:    "/notifications/stream",
+		Summary: "Stream a user's notifications",
+		Description: "Events carry increasing IDs. A reconnecting client that sends the last ID it got gets the events it missed replayed, " +
+			"or its unread notifications when they can't all be replayed. " +
+			"Events are named with an event: line (connected, new_notification, notification_read, ...) and heartbeats are comments; " +
+			"format=legacy sends the older unnamed data: messages instead. " +
+			"When the server shuts down, streams get server_shutdown with a reconnect delay and new streams get 503.",
+		Tags: []string{tagStream},
+		Params: []openapi.Param{
+			userIDParam,
+			openapi.Header("Last-Event-ID", "ID of the last event received; sent by EventSource when it reconnects"),
+			openapi.Query("last_event_id", "Same as Last-Event-ID, for clients that can't set headers"),
+			{Name: "format", In: "query", Description: "Wire format; defaults to the server's SSE_FORMAT", Enum: []string{"events", "legacy"}},
+		},
+		Responses: []openapi.Reply{{Description: "Server-sent events; see /v1/sse-help", ContentType: "text/event-stream"}},
+		Handlers:  []fiber.Handler{StreamNotifications(store, hub)},
+	})
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodGet,
+		Path:      "/sse-help",
+		Summary:   "Describe the stream's events",
+		Tags:      []string{tagStream},
+		Responses: []openapi.Reply{{Description: "Plain text guide", ContentType: fiber.MIMETextPlainCharsetUTF8}},
+		Handlers:  []fiber.Handler{SSEHelpHandler()},
+	})
+	v1.Add(openapi.Route{
+		Method:    fiber.MethodGet,
+		Path:      "/test/sse",
+		Summary:   "Send a few test events",
+		Tags:      []string{tagStream},
+		Responses: []openapi.Reply{{Description: "Server-sent events", ContentType: "text/event-stream"}},
+		Handlers:  []fiber.Handler{TestSSEHandler()},
+	})
+
+	// Single notification routes; added last so :id doesn't shadow the routes above
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodGet,
+		Path:        "/notifications/:id",
+		Summary:     "Get one notification",
+		Description: "With user_id only that user's notifications match and system read state is theirs.",
+		Tags:        []string{tagRead},
+		Params:      []openapi.Param{openapi.Query("user_id", "The notification's recipient")},
+		Responses:   []openapi.Reply{{Description: "The notification as an inbox item", Body: models.InboxItem{}}},
+		Handlers:    []fiber.Handler{GetNotification(store)},
+	})
+	v1.Add(openapi.Route{
+		Method:      fiber.MethodDelete,
+		Path:        "/notifications/:id",
+		Summary:     "Delete one notification",
+		Description: "System notifications are dismissed for the user instead.",
+		Tags:        []string{tagUpdate},
+		Params:      []openapi.Param{userIDParam},
+		Responses:   []openapi.Reply{{Description: "The deleted notification", Body: deletedNotification{}}},
+		Handlers:    []fiber.Handler{DeleteNotification(store, hub)},
+	})
+
+	api.Serve("/openapi.json", "/docs")
+}
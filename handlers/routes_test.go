@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/openapi"
+	"github.com/ktappdev/noti-service/retention"
+	"github.com/ktappdev/noti-service/sse"
+)
+
+// newTestAPI registers every route on a fresh app backed by the memory store
+func newTestAPI() (*fiber.App, *openapi.Registry) {
+	return newTestAPIWithStore(database.NewMemoryStore())
+}
+
+// newTestAPIWithStore registers every route on a fresh app backed by store
+func newTestAPIWithStore(store database.Store) (*fiber.App, *openapi.Registry) {
+	return newTestAPIWithHub(store, sse.NewSSEHub())
+}
+
+// newTestAPIWithHub registers every route on a fresh app backed by store
+// that streams through hub
+func newTestAPIWithHub(store database.Store, hub *sse.SSEHub) (*fiber.App, *openapi.Registry) {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	api := openapi.NewRegistry(app, openapi.Info{Title: "test", Version: "test"}, ErrorResponse{})
+	RegisterRoutes(api, store, hub, retention.NewWorker(store, retention.Config{}))
+	return app, api
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	app, api := newTestAPI()
+
+	mounted := make(map[string]bool)
+	for _, route := range app.GetRoutes(true) {
+		method := route.Method
+		if method == fiber.MethodHead {
+			// Added alongside every GET route
+			method = fiber.MethodGet
+		}
+		mounted[method+" "+route.Path] = true
+
+		op := api.Operation(method, route.Path)
+		if op == nil {
+			t.Errorf("%s %s is not in the OpenAPI document; add it with openapi.Registry.Add", route.Method, route.Path)
+			continue
+		}
+		if op.Summary == "" {
+			t.Errorf("%s %s has no summary", method, route.Path)
+		}
+		if len(op.Responses) < 2 {
+			t.Errorf("%s %s documents no success response", method, route.Path)
+		}
+	}
+
+	for path, item := range api.Document().Paths {
+		for method := range item {
+			fiberPath := path
+			for _, param := range strings.Split(path, "/") {
+				if strings.HasPrefix(param, "{") {
+					fiberPath = strings.Replace(fiberPath, param, ":"+strings.Trim(param, "{}"), 1)
+				}
+			}
+			if !mounted[strings.ToUpper(method)+" "+fiberPath] {
+				t.Errorf("%s %s is documented but not mounted", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIDocumentIsServed(t *testing.T) {
+	app, _ := newTestAPI()
+
+	res, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/openapi.json", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc openapi.Document
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		t.Fatalf("decoding /openapi.json: %v", err)
+	}
+	if doc.OpenAPI != openapi.Version {
+		t.Errorf("openapi = %q, want %q", doc.OpenAPI, openapi.Version)
+	}
+	if doc.Paths["/notifications/{id}"]["get"] == nil {
+		t.Error("GET /notifications/{id} missing from the served document")
+	}
+
+	// Every $ref must point at a component
+	raw, _ := json.Marshal(doc)
+	for _, part := range strings.Split(string(raw), `"$ref":"#/components/schemas/`)[1:] {
+		name := part[:strings.Index(part, `"`)]
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("$ref to missing schema %s", name)
+		}
+	}
+
+	res, err = app.Test(httptest.NewRequest(fiber.MethodGet, "/docs", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(res.Body)
+	if res.StatusCode != fiber.StatusOK || !strings.Contains(string(page), `"/openapi.json"`) {
+		t.Errorf("/docs returned %d without a link to the document", res.StatusCode)
+	}
+}
+
+func TestLegacyAliasHeaders(t *testing.T) {
+	app, _ := newTestAPI()
+
+	tests := []struct {
+		path            string
+		wantDeprecation string
+		wantSunset      string
+		wantLink        string
+	}{
+		{"/v1/sse-help", "", "", ""},
+		{"/sse-help", "@1792108800", "Fri, 16 Apr 2027 00:00:00 GMT", `</v1/sse-help>; rel="successor-version"`},
+	}
+	for _, tt := range tests {
+		res, err := app.Test(httptest.NewRequest(fiber.MethodGet, tt.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != fiber.StatusOK {
+			t.Fatalf("GET %s: got %d, want 200", tt.path, res.StatusCode)
+		}
+		for header, want := range map[string]string{
+			"Deprecation":    tt.wantDeprecation,
+			"Sunset":         tt.wantSunset,
+			fiber.HeaderLink: tt.wantLink,
+		} {
+			if got := res.Header.Get(header); got != want {
+				t.Errorf("GET %s: %s = %q, want %q", tt.path, header, got, want)
+			}
+		}
+	}
+}
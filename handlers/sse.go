@@ -3,23 +3,39 @@ package handlers
 import (
 	"bufio"
 	"encoding/json"
-	"fmt"
 	"log"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/jmoiron/sqlx"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
 	"github.com/ktappdev/noti-service/models"
 	"github.com/ktappdev/noti-service/sse"
 	"github.com/valyala/fasthttp"
 )
 
 // StreamNotifications handles SSE connections for real-time notifications
-func StreamNotifications(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
+func StreamNotifications(store database.Store, hub *sse.SSEHub) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Query("user_id")
 		if userID == "" {
-			return c.Status(400).SendString("user_id query parameter is required")
+			return apperr.MissingParameter("user_id")
+		}
+
+		// Send browsers elsewhere while this instance drains
+		if hub.ShuttingDown() {
+			c.Set(fiber.HeaderRetryAfter, "5")
+			return apperr.New(fiber.StatusServiceUnavailable, apperr.CodeServiceUnavailable, "Server is shutting down, reconnect shortly")
+		}
+
+		// Clients written against the old unnamed messages can ask for them
+		format := hub.Config().Format
+		if v := c.Query("format"); v != "" {
+			parsed, err := sse.ParseFormat(v)
+			if err != nil {
+				return apperr.InvalidParameter("format", "format must be events or legacy")
+			}
+			format = parsed
 		}
 
 		// Set SSE headers for proper streaming
@@ -31,35 +47,44 @@ func StreamNotifications(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
 		// Don't set Content-Length - let it stream
 		// CORS headers are handled by the main middleware, don't override here
 
-		// Generate unique client ID
-		clientID := fmt.Sprintf("%s_%d", userID, time.Now().UnixNano())
-
 		// Create SSE client
-		client := &sse.SSEClient{
-			UserID:  userID,
-			Channel: make(chan []byte, 10),
-			Done:    make(chan bool),
-			ID:      clientID,
-		}
+		client := hub.NewClient(userID, format)
 
-		// Register client
-		hub.RegisterClient(client)
+		// Register client. A reconnecting EventSource sends the ID of the
+		// last event it got; replay what it missed since then.
+		lastEventID := c.Get("Last-Event-ID", c.Query("last_event_id"))
+		var replay [][]byte
+		resumed := false
+		if lastEventID != "" {
+			replay, resumed = hub.ResumeClient(client, lastEventID)
+			if !resumed {
+				log.Printf("Can't replay events after %s for user %s, sending unread notifications instead", lastEventID, userID)
+			}
+		} else {
+			hub.RegisterClient(client)
+		}
 
 		// Send initial connection message immediately to establish stream
 		initialMsg := models.NotificationMessage{
 			UserID: userID,
 			Type:   "system",
 			Event:  "connected",
-			Notification: map[string]string{
+			Notification: map[string]interface{}{
 				"message": "Connected to notification stream",
 				"time":    time.Now().Format(time.RFC3339),
+				"resumed": resumed,
 			},
 		}
 		initialData, _ := json.Marshal(initialMsg)
 		
-		// Write initial message and flush immediately
-		initialResponse := fmt.Sprintf("data: %s\n\n", initialData)
-		c.Write([]byte(initialResponse))
+		// Write initial message and flush immediately, telling the browser
+		// how long to wait before reconnecting
+		var initialResponse []byte
+		if retry := hub.Config().Retry; retry > 0 {
+			initialResponse = append(initialResponse, sse.Retry(retry)...)
+		}
+		initialResponse = append(initialResponse, client.Frame(0, "connected", initialData)...)
+		c.Write(initialResponse)
 		
 		// Use the working streaming approach without problematic channels
 		c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
@@ -78,7 +103,7 @@ func StreamNotifications(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
 			}
 			
 			// Write the initial message first
-			if _, err := w.WriteString(initialResponse); err != nil {
+			if _, err := w.Write(initialResponse); err != nil {
 				log.Printf("Error writing initial SSE message: %v", err)
 				return
 			}
@@ -86,13 +111,27 @@ func StreamNotifications(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
 				log.Printf("Error flushing initial SSE message: %v", err)
 				return
 			}
-			
-			
-			// Send existing notifications after initial message
-			go func() {
-				time.Sleep(100 * time.Millisecond)
-				sendExistingNotifications(db, client)
-			}()
+
+			// Replay missed events before any new ones
+			for _, frame := range replay {
+				if _, err := w.Write(frame); err != nil {
+					log.Printf("Error replaying SSE events for user %s: %v", userID, err)
+					return
+				}
+			}
+			if err := w.Flush(); err != nil {
+				log.Printf("Error flushing replayed SSE events for user %s: %v", userID, err)
+				return
+			}
+
+			// Send existing notifications after initial message, unless the
+			// replay already brought the client up to date
+			if !resumed {
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					sendExistingNotifications(store, client, hub.Config().ClientBuffer)
+				}()
+			}
 			
 			// Use polling approach instead of select with channels
 			for {
@@ -113,9 +152,7 @@ func StreamNotifications(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
 					
 				case <-time.After(30 * time.Second):
 					// Send heartbeat every 30 seconds to keep connection alive
-					heartbeat := fmt.Sprintf("data: {\"type\": \"heartbeat\", \"timestamp\": \"%s\"}\n\n", 
-						time.Now().Format(time.RFC3339))
-					if _, err := w.WriteString(heartbeat); err != nil {
+					if _, err := w.Write(client.Heartbeat(time.Now())); err != nil {
 						log.Printf("Error writing heartbeat for user %s: %v", userID, err)
 						return
 					}
@@ -128,6 +165,14 @@ func StreamNotifications(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
 				// Check if client is done (non-blocking)
 				select {
 				case <-client.Done:
+					// Write what the hub queued before ending the stream,
+					// e.g. server_shutdown
+					for message := range client.Channel {
+						if _, err := w.Write(message); err != nil {
+							return
+						}
+					}
+					w.Flush()
 					return
 				default:
 					// Continue loop
@@ -139,29 +184,20 @@ func StreamNotifications(db *sqlx.DB, hub *sse.SSEHub) fiber.Handler {
 	}
 }
 
-func sendExistingNotifications(db *sqlx.DB, client *sse.SSEClient) {
-	// Get unread user notifications
-	userQuery := `SELECT * FROM user_notifications
-                  WHERE parent_user_id = $1 AND read = false
-                  ORDER BY created_at DESC`
-	var userNotifications []models.UserNotification
-	err := db.Select(&userNotifications, userQuery, client.UserID)
-	if err != nil {
-		log.Printf("Error fetching user notifications for SSE: %v", err)
-	}
-
-	// Get unread owner notifications
-	ownerQuery := `SELECT * FROM product_owner_notifications
-                   WHERE owner_id = $1 AND read = false
-                   ORDER BY created_at DESC`
-	var ownerNotifications []models.ProductOwnerNotification
-	err = db.Select(&ownerNotifications, ownerQuery, client.UserID)
+func sendExistingNotifications(store database.Store, client *sse.SSEClient, limit int) {
+	// Get the newest unread notifications, no more than fit in the client's
+	// buffer
+	query := database.Unread()
+	query.Kinds = []string{models.KindUser, models.KindOwner, models.KindSystem}
+	query.Limit = limit
+	set, err := store.ListNotifications(client.UserID, query)
 	if err != nil {
-		log.Printf("Error fetching owner notifications for SSE: %v", err)
+		log.Printf("Error fetching notifications for SSE: %v", err)
+		return
 	}
 
 	// Send user notifications
-	for _, notification := range userNotifications {
+	for _, notification := range set.User {
 		message := models.NotificationMessage{
 			UserID:       client.UserID,
 			Type:         "user",
@@ -169,17 +205,14 @@ func sendExistingNotifications(db *sqlx.DB, client *sse.SSEClient) {
 			Notification: notification,
 		}
 		messageBytes, _ := json.Marshal(message)
-		sseData := fmt.Sprintf("data: %s\n\n", messageBytes)
 
-		select {
-		case client.Channel <- []byte(sseData):
-		default:
+		if !client.Send(client.Frame(0, message.Event, messageBytes)) {
 			log.Printf("Failed to send existing user notification to client %s", client.ID)
 		}
 	}
 
 	// Send owner notifications
-	for _, notification := range ownerNotifications {
+	for _, notification := range set.Owner {
 		message := models.NotificationMessage{
 			UserID:       client.UserID,
 			Type:         "owner",
@@ -187,12 +220,29 @@ func sendExistingNotifications(db *sqlx.DB, client *sse.SSEClient) {
 			Notification: notification,
 		}
 		messageBytes, _ := json.Marshal(message)
-		sseData := fmt.Sprintf("data: %s\n\n", messageBytes)
 
-		select {
-		case client.Channel <- []byte(sseData):
-		default:
+		if !client.Send(client.Frame(0, message.Event, messageBytes)) {
 			log.Printf("Failed to send existing owner notification to client %s", client.ID)
 		}
 	}
+
+	// Send system notifications, including announcements to everyone
+	for _, notification := range set.System {
+		message := models.NotificationMessage{
+			UserID:       client.UserID,
+			Type:         "system",
+			Event:        "existing_notification",
+			Notification: notification,
+		}
+		messageBytes, _ := json.Marshal(message)
+
+		if !client.Send(client.Frame(0, message.Event, messageBytes)) {
+			log.Printf("Failed to send existing system notification to client %s", client.ID)
+		}
+	}
+
+	// The rest are left for the client to fetch over REST
+	if set.NextCursor != nil {
+		client.Resync("too_many_unread")
+	}
 }
\ No newline at end of file
@@ -58,7 +58,7 @@ HOW SSE WORKS TECHNICALLY
 OUR SSE IMPLEMENTATION DETAILS
 ==============================
 
-ENDPOINT: GET /notifications/stream?user_id=USER_ID
+ENDPOINT: GET /v1/notifications/stream?user_id=USER_ID
 
 REQUIRED HEADERS (set automatically):
 - Content-Type: text/event-stream
@@ -69,15 +69,18 @@ ARCHITECTURE COMPONENTS:
 
 1. SSE HUB (sse/hub.go):
    - Central manager for all SSE connections
-   - Maintains map of userID -> []*SSEClient
+   - Maintains map of userID -> []*SSEClient, split across SSE_SHARDS
+     shards by user id (default GOMAXPROCS), each with its own lock and
+     worker (sse/shard.go)
    - Handles client registration/unregistration
-   - Broadcasts messages to specific users
+   - Broadcasts messages to specific users, in order per user
    - Runs in separate goroutine
+   - Benchmarks: go test ./sse -run '^$' -bench . -cpu 1,4,8
 
 2. SSE CLIENT STRUCTURE:
    type SSEClient struct {
        UserID  string        // User identifier
-       Channel chan []byte   // Message queue (buffered, size 10)
+       Channel chan []byte   // Message queue (SSE_CLIENT_BUFFER, default 64)
        Done    chan bool     // Cleanup signal
        ID      string        // Unique client ID (userID_timestamp)
    }
@@ -95,10 +98,66 @@ ARCHITECTURE COMPONENTS:
    - Integrated into notification creation endpoints
    - Integrated into notification read status updates
    - Uses hub.BroadcastToUser() method
-   - Non-blocking sends (drops if client channel full)
-
-MESSAGE TYPES SENT:
-==================
+   - Broadcast system notifications use hub.BroadcastToAll(), which
+     delivers to every connected user in batches of 500 from a snapshot,
+     releasing the hub between batches, and logs how many clients it reached
+   - Non-blocking sends: a client that falls SSE_CLIENT_BUFFER events
+     behind has further events dropped (and counted) and is sent a
+     resync_required event telling it to refetch its notifications
+   - A client still that far behind after SSE_STALL_TIMEOUT (default 30s)
+     is disconnected; its browser reconnects with Last-Event-ID
+
+5. RESUMING (Last-Event-ID):
+   - Every broadcast carries an "id:" line with a monotonic event id
+   - The hub keeps the last SSE_REPLAY_SIZE events per user (default 100)
+     for SSE_REPLAY_TTL (default 10m)
+   - Browsers send the last id back in the Last-Event-ID header when they
+     reconnect; other clients can pass ?last_event_id=ID instead
+   - If every missed event is still in the log they are replayed in order
+     and the connected message has "resumed": true
+   - Otherwise (unknown id, log evicted, server restarted, or a broadcast
+     system notification sent since) the stream starts over with
+     "resumed": false and the unread notifications
+
+6. EVENT FORMAT:
+   - Every message is a named event, so clients can addEventListener
+     for just the events they handle:
+       id: 1792194378802875
+       event: new_notification
+       data: {"user_id":"user123","type":"user","event":"new_notification",...}
+   - The stream starts with "retry: 3000" so browsers wait SSE_RETRY
+     (default 3s) before reconnecting
+   - Heartbeats are comment lines (": heartbeat 2024-01-01T12:00:00Z")
+     that EventSource ignores
+   - ?format=legacy (or SSE_FORMAT=legacy on the server) sends the old
+     format instead: unnamed "data:" messages handled with onmessage and
+     heartbeats as {"type": "heartbeat"} data messages
+
+7. MULTIPLE INSTANCES (sse/bridge.go):
+   - With the Postgres store, every event is also published with
+     NOTIFY on SSE_BRIDGE_CHANNEL (default noti_sse_events)
+   - Every instance LISTENs on it and delivers the events to its own
+     clients, so a stream gets them whichever instance it is on
+   - Events keep their id across instances; an instance ignores its own
+     events and ones it has already delivered
+   - The listener reconnects on its own; clients resuming from before a
+     reconnect get their unread notifications instead of a replay
+   - Events over Postgres's 8000 byte NOTIFY limit are published without
+     their data; other instances send the user resync_required instead
+   - SSE_BRIDGE=false turns the bridge off for single-instance setups
+
+8. SHUTDOWN (SIGTERM/SIGINT):
+   - Every stream is sent server_shutdown with a retry: line spreading
+     reconnects over one to two times SSE_RETRY, then ended; a load
+     balancer sends the reconnect to another instance
+   - New streams get 503 SERVICE_UNAVAILABLE with Retry-After
+   - Requests in flight finish and their events are still logged and
+     relayed to other instances before the hub and database close
+   - SHUTDOWN_TIMEOUT (default 20s) bounds the whole shutdown; requests
+     get three quarters of it, closing the hub, bridge and database the rest
+
+MESSAGE TYPES SENT (event name, then data):
+===========================================
 
 1. CONNECTION CONFIRMATION:
 {
@@ -107,14 +166,16 @@ MESSAGE TYPES SENT:
   "event": "connected",
   "notification": {
     "message": "Connected to notification stream",
-    "time": "2024-01-01T12:00:00Z"
+    "time": "2024-01-01T12:00:00Z",
+    "resumed": false
   }
 }
 
-2. EXISTING NOTIFICATIONS (on connect):
+2. EXISTING NOTIFICATIONS (on connect, skipped when resumed; at most
+   SSE_CLIENT_BUFFER, followed by resync_required if there are more):
 {
   "user_id": "user123", 
-  "type": "user" | "owner",
+  "type": "user" | "owner" | "system",
   "event": "existing_notification",
   "notification": { /* full notification object */ }
 }
@@ -140,32 +201,93 @@ MESSAGE TYPES SENT:
   }
 }
 
+5. UNREAD COUNT UPDATES (after creates and reads):
+{
+  "user_id": "user123",
+  "type": "user" | "owner" | "like" | "system",
+  "event": "unread_count",
+  "notification": {
+    "user": 3,
+    "owner": 1,
+    "like": 0,
+    "system": 2,
+    "total": 6
+  }
+}
+
+6. BULK READ UPDATES (mark-all-read and read-by-ids):
+{
+  "user_id": "user123",
+  "type": "",
+  "event": "notifications_read_bulk",
+  "notification": {
+    "updated": { "user": 2, "owner": 0, "like": 1, "system": 0, "total": 3 },
+    "ids": ["notif1", "notif2", "notif3"],
+    "timestamp": "2024-01-01T12:00:00Z"
+  }
+}
+("ids" is only sent for read-by-ids; mark-all-read sends its filters instead)
+
+7. DELETIONS (single and bulk; system notifications are dismissed):
+{
+  "user_id": "user123",
+  "type": "",
+  "event": "notification_deleted",
+  "notification": {
+    "notifications": [{ "id": "notif123", "type": "user" }],
+    "deleted": { "user": 1, "owner": 0, "like": 0, "system": 0, "total": 1 },
+    "timestamp": "2024-01-01T12:00:00Z"
+  }
+}
+
+8. RESYNC REQUIRED (events were dropped or may have been missed):
+{
+  "user_id": "user123",
+  "type": "system",
+  "event": "resync_required",
+  "notification": {
+    "reason": "slow_consumer" | "events_missed" | "too_many_unread",
+    "dropped": 3,
+    "time": "2024-01-01T12:00:00Z"
+  }
+}
+Refetch notifications and counts over REST when this arrives.
+
+9. SERVER SHUTDOWN (the server is restarting; the stream ends next):
+{
+  "user_id": "user123",
+  "type": "system",
+  "event": "server_shutdown",
+  "notification": {
+    "message": "Server is shutting down, reconnect shortly",
+    "reconnect_after_ms": 4200,
+    "time": "2024-01-01T12:00:00Z"
+  }
+}
+EventSource reconnects by itself after reconnect_after_ms and resumes
+with Last-Event-ID.
+
 FRONTEND USAGE EXAMPLE:
 ======================
 
 // Connect to SSE stream
-const eventSource = new EventSource('http://localhost:3001/notifications/stream?user_id=user123');
-
-// Handle all messages
-eventSource.onmessage = function(event) {
-    const data = JSON.parse(event.data);
-    console.log('Received:', data);
-    
-    switch(data.event) {
-        case 'connected':
-            console.log('Connected to notification stream');
-            break;
-        case 'new_notification':
-            showNewNotification(data.notification);
-            break;
-        case 'notification_read':
-            updateNotificationStatus(data.notification);
-            break;
-        case 'existing_notification':
-            addExistingNotification(data.notification);
-            break;
-    }
-};
+const eventSource = new EventSource('http://localhost:3001/v1/notifications/stream?user_id=user123');
+
+// Listen for the events you handle
+function on(name, handler) {
+    eventSource.addEventListener(name, function(event) {
+        handler(JSON.parse(event.data).notification);
+    });
+}
+on('connected', function() { console.log('Connected to notification stream'); });
+on('new_notification', showNewNotification);
+on('notification_read', updateNotificationStatus);
+on('existing_notification', addExistingNotification);
+on('unread_count', function(counts) { updateBadge(counts.total); });
+on('notifications_read_bulk', refreshNotifications);
+on('notification_deleted', function(n) { removeNotifications(n.notifications); });
+on('resync_required', refreshNotifications);
+on('server_shutdown', function(n) { console.log('Server restarting, reconnecting in', n.reconnect_after_ms, 'ms'); });
 
 // Handle connection errors
 eventSource.onerror = function(event) {
@@ -207,10 +329,10 @@ TESTING THE IMPLEMENTATION:
 
 2. TEST SSE CONNECTION:
    curl -N -H "Accept: text/event-stream" \
-   "http://localhost:3001/notifications/stream?user_id=test123"
+   "http://localhost:3001/v1/notifications/stream?user_id=test123"
 
 3. CREATE NOTIFICATION (in another terminal):
-   curl -X POST http://localhost:3001/notifications/product-owner \
+   curl -X POST http://localhost:3001/v1/notifications/product-owner \
    -H "Content-Type: application/json" \
    -d '{"owner_id":"test123","product_name":"Test Product","from_name":"Test User"}'
 
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/models"
+	"github.com/ktappdev/noti-service/sse"
+)
+
+func TestSendExistingNotifications(t *testing.T) {
+	tests := []struct {
+		name       string
+		unread     int
+		wantFrames int
+		wantResync bool
+	}{
+		{"fits in the buffer", 2, 3, false},
+		{"more than the buffer", 5, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewMemoryStore()
+			for _, id := range []string{"user_a", "user_b"} {
+				if err := store.UpsertUser(&models.User{ID: id}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < tt.unread; i++ {
+				err := store.CreateUserNotification(&models.UserNotification{
+					ID: fmt.Sprintf("n%d", i), ParentUserID: "user_b", FromID: "user_a", NotificationType: "comment",
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A broadcast system notification is sent along with the user's own
+			err := store.CreateSystemNotification(&models.SystemNotification{ID: "s1", Title: "Maintenance", Message: "Down at noon"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Likes aren't sent on connect
+			err = store.CreateLikeNotification(&models.LikeNotification{
+				ID: "like", TargetUserID: "user_b", TargetType: "review", TargetID: "r1", FromID: "user_a",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := sse.DefaultConfig()
+			config.ClientBuffer = 3
+			client := sse.NewSSEHubWithConfig(config).NewClient("user_b", sse.FormatEvents)
+			sendExistingNotifications(store, client, config.ClientBuffer)
+			close(client.Channel)
+
+			frames, resync := 0, false
+			for frame := range client.Channel {
+				switch {
+				case strings.Contains(string(frame), "event: existing_notification"):
+					if strings.Contains(string(frame), `"type":"like"`) {
+						t.Errorf("like notification sent: %s", frame)
+					}
+					frames++
+				case strings.Contains(string(frame), "too_many_unread"):
+					resync = true
+				default:
+					t.Errorf("unexpected frame %q", frame)
+				}
+			}
+			if frames != tt.wantFrames || resync != tt.wantResync {
+				t.Errorf("got %d notifications, resync %v; want %d, resync %v", frames, resync, tt.wantFrames, tt.wantResync)
+			}
+		})
+	}
+}
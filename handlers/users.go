@@ -2,21 +2,22 @@ package handlers
 
 import (
 	"github.com/gofiber/fiber/v2"
-	"github.com/jmoiron/sqlx"
+	"github.com/ktappdev/noti-service/apperr"
+	"github.com/ktappdev/noti-service/database"
 	"github.com/ktappdev/noti-service/models"
+	"github.com/ktappdev/noti-service/validate"
 )
 
 // CreateUser creates a new user (idempotent - handles duplicates gracefully)
-func CreateUser(db *sqlx.DB) fiber.Handler {
+func CreateUser(store database.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		user := new(models.User)
-		if err := c.BodyParser(user); err != nil {
-			return c.Status(400).SendString(err.Error())
+		errs, err := decodeBody(c.Body(), user, dedupeKeyField)
+		if err != nil {
+			return err
 		}
-
-		// Validate required field
-		if user.ID == "" {
-			return c.Status(400).SendString("User ID is required")
+		if err := validationError(append(errs, validate.Struct(user)...)); err != nil {
+			return err
 		}
 
 		// Provide defaults for nullable fields to prevent DB errors
@@ -27,29 +28,14 @@ func CreateUser(db *sqlx.DB) fiber.Handler {
 			user.FullName = "User " + user.ID // Default full name based on ID
 		}
 
-		// Use PostgreSQL's UPSERT (INSERT ... ON CONFLICT) for true idempotency
-		query := `
-			INSERT INTO users (id, username, full_name) 
-			VALUES ($1, $2, $3) 
-			ON CONFLICT ON CONSTRAINT users_pkey DO UPDATE SET 
-				username = COALESCE(NULLIF(EXCLUDED.username, ''), users.username),
-				full_name = COALESCE(NULLIF(EXCLUDED.full_name, ''), users.full_name)
-			RETURNING id, username, full_name`
-		
-		var resultUser models.User
-		err := db.QueryRow(query, user.ID, user.Username, user.FullName).Scan(
-			&resultUser.ID, 
-			&resultUser.Username, 
-			&resultUser.FullName,
-		)
-		if err != nil {
-			return c.Status(500).SendString("Database error: " + err.Error())
+		// The store upserts (INSERT ... ON CONFLICT in Postgres) for true idempotency
+		resultUser := *user
+		if err := store.UpsertUser(&resultUser); err != nil {
+			return apperr.Internal("Failed to save user", err)
 		}
 
 		// Check if this was an insert (new user) or update (existing user)
-		var exists bool
-		err = db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", user.ID)
-		if err != nil {
+		if _, err := store.UserExists(user.ID); err != nil {
 			// If we can't determine, assume it was created (safer for logging)
 			return c.Status(201).JSON(resultUser)
 		}
@@ -58,4 +44,4 @@ func CreateUser(db *sqlx.DB) fiber.Handler {
 		// Since we used ON CONFLICT, we can't easily distinguish, so return 200 (idempotent behavior)
 		return c.Status(200).JSON(resultUser)
 	}
-}
\ No newline at end of file
+}
@@ -6,10 +6,13 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/jmoiron/sqlx"
 	"github.com/joho/godotenv"
 	"github.com/ktappdev/noti-service/database"
 	"github.com/ktappdev/noti-service/handlers"
+	"github.com/ktappdev/noti-service/openapi"
+	"github.com/ktappdev/noti-service/retention"
 	"github.com/ktappdev/noti-service/sse"
 	_ "github.com/lib/pq"
 )
@@ -25,61 +28,122 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		log.Fatal("DATABASE_URL environment variable is required")
+	var store database.Store
+	if os.Getenv("STORE_BACKEND") == "memory" {
+		// There is no schema to migrate; don't start the server instead
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			log.Fatal("migrate needs the Postgres store; unset STORE_BACKEND=memory")
+		}
+
+		// Handy for local development and demos; nothing survives a restart
+		log.Printf("Using in-memory notification store")
+		store = database.NewMemoryStore()
+	} else {
+		connStr := os.Getenv("DATABASE_URL")
+		if connStr == "" {
+			log.Fatal("DATABASE_URL environment variable is required")
+		}
+
+		var err error
+		db, err = sqlx.Connect("postgres", connStr)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// `noti-service migrate ...` manages the schema and exits. Otherwise
+		// the shutdown teardown closes the database.
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			err := runMigrateCommand(db, os.Args[2:])
+			db.Close()
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		// Apply any pending migrations on boot unless they are managed separately
+		if os.Getenv("SKIP_MIGRATIONS") != "true" {
+			if err := database.Migrate(db); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		store = database.NewPostgresStore(db)
 	}
 
-	var err error
-	db, err = sqlx.Connect("postgres", connStr)
+	// Retention rules come from RETENTION_* variables. The worker always runs
+	// because it also clears out expired idempotency keys.
+	retentionConfig, err := retention.ConfigFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
+	retentionWorker := retention.NewWorker(store, retentionConfig)
+	go retentionWorker.Run()
 
-	defer db.Close()
-
-	if err := database.CreateSchema(db); err != nil {
+	// Initialize and start SSE hub
+	sseConfig, err := sse.ConfigFromEnv()
+	if err != nil {
 		log.Fatal(err)
 	}
-
-	// Initialize and start SSE hub
-	sseHub = sse.NewSSEHub()
+	shutdownTimeout, err := shutdownTimeoutFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sseHub = sse.NewSSEHubWithConfig(sseConfig)
+
+	// With Postgres, instances share events over LISTEN/NOTIFY so a stream
+	// gets them whichever instance it is connected to
+	var bridge *sse.PostgresBridge
+	if db != nil && sseConfig.Bridge {
+		bridge = sse.NewPostgresBridge(db, os.Getenv("DATABASE_URL"), sseConfig.BridgeChannel, sseHub)
+		handlers.PublishRemoteBroadcastCounts(store, sseHub)
+		go bridge.Run()
+	}
 	go sseHub.Run()
 
-	app := fiber.New()
+	// Errors returned by handlers are rendered as JSON with a stable code
+	app := fiber.New(fiber.Config{
+		ErrorHandler: handlers.ErrorHandler,
+	})
+	app.Use(requestid.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "*",
 		AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders:     "Origin, Content-Type, Accept, Cache-Control, Authorization, X-Requested-With",
+		AllowHeaders:     "Origin, Content-Type, Accept, Cache-Control, Authorization, X-Requested-With, Idempotency-Key, X-Request-ID, Last-Event-ID",
 		AllowCredentials: false,
-		ExposeHeaders:    "Content-Length, Content-Type",
+		ExposeHeaders:    "Content-Length, Content-Type, Idempotent-Replayed, X-Request-ID, Deprecation, Sunset, Link",
 	}))
 	// app.Use(logger.New(logger.Config{
 	// 	Format: "[${ip}]:${port} ${status} - ${method} ${path}\n",
 	// }))
 
-	// Routes
-	app.Post("/users", handlers.CreateUser(db))
-	app.Post("/notifications/product-owner", handlers.CreateProductOwnerNotification(db, sseHub))
-	app.Post("/notifications/comment", handlers.CreateCommentNotification(db, sseHub))
-	app.Post("/notifications/reply", handlers.CreateReplyNotification(db, sseHub))
-	app.Post("/notifications/like", handlers.CreateLikeNotification(db, sseHub))
-	app.Post("/notifications/system", handlers.CreateSystemNotification(db, sseHub))
-	app.Get("/notifications/latest", handlers.GetLatestNotifications(db))
-	app.Get("/notifications", handlers.GetAllNotifications(db))
-	app.Get("/notifications/unread", handlers.GetAllUnreadNotifications(db))
-	app.Delete("/notifications", handlers.DeleteReadNotifications(db))
-	app.Put("/notifications/:id/read", handlers.MarkNotificationAsRead(db, sseHub))
-
-	// SSE route
-	app.Get("/notifications/stream", handlers.StreamNotifications(db, sseHub))
-	
-	// SSE documentation route
-	app.Get("/sse-help", handlers.SSEHelpHandler())
-	
-	// Test SSE endpoint
-	app.Get("/test/sse", handlers.TestSSEHandler())
+	// Every route is registered through the OpenAPI registry, which serves
+	// the generated document at /openapi.json and the docs at /docs
+	api := openapi.NewRegistry(app, openapi.Info{
+		Title:       "Noti Service API",
+		Description: "Notifications for ReviewIt users, delivered over REST and server-sent events",
+		Version:     "1.0.0",
+	}, handlers.ErrorResponse{})
+	handlers.RegisterRoutes(api, store, sseHub, retentionWorker)
 
 	log.Printf("Server starting on port 3001...")
-	log.Fatal(app.Listen(":3001"))
+	go func() {
+		if err := app.Listen(":3001"); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// Once the hub has delivered the last events, the bridge publishes them,
+	// then the retention worker and database stop, all within the deadline
+	waitForShutdown(app, sseHub, shutdownTimeout, func() {
+		if bridge != nil {
+			bridge.Stop()
+		}
+		retentionWorker.Stop()
+		if db != nil {
+			if err := db.Close(); err != nil {
+				log.Printf("Error closing database: %v", err)
+			}
+		}
+	})
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/ktappdev/noti-service/database"
+)
+
+const migrateUsage = "usage: noti-service migrate [up | down [steps] | status]"
+
+// runMigrateCommand handles `noti-service migrate ...` so schema changes can be
+// applied or rolled back without starting the HTTP server
+func runMigrateCommand(db *sqlx.DB, args []string) error {
+	if len(args) == 0 {
+		args = []string{"up"}
+	}
+
+	switch args[0] {
+	case "up":
+		return database.Migrate(db)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q\n%s", args[1], migrateUsage)
+			}
+			steps = n
+		}
+		return database.MigrateDown(db, steps)
+
+	case "status":
+		status, err := database.GetMigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", m.Version, m.Name, applied)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+}
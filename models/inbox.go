@@ -0,0 +1,87 @@
+package models
+
+import "time"
+
+// InboxActor is the user whose action produced a notification
+type InboxActor struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// InboxTarget is the content a notification is about
+type InboxTarget struct {
+	Type      string `json:"type"` // "review", "comment" or "product"
+	ID        string `json:"id"`
+	ProductID string `json:"product_id,omitempty"`
+}
+
+// InboxItem is the common envelope for every notification kind in the merged feed
+type InboxItem struct {
+	ID        string       `json:"id"`
+	Kind      string       `json:"kind"` // "user", "owner", "like" or "system"
+	CreatedAt time.Time    `json:"created_at"`
+	Read      bool         `json:"read"`
+	Actor     *InboxActor  `json:"actor"`   // nil for system notifications
+	Target    *InboxTarget `json:"target"`  // nil for system notifications
+	Payload   interface{}  `json:"payload"` // the full notification of this kind
+}
+
+// InboxItemFromUser wraps a comment or reply notification. Comments target the
+// review that was commented on, replies the comment that was replied to.
+func InboxItemFromUser(n UserNotification) InboxItem {
+	target := &InboxTarget{Type: "review", ID: n.ReviewID, ProductID: n.ProductID}
+	if n.NotificationType == "reply" {
+		target = &InboxTarget{Type: "comment", ID: n.ParentID, ProductID: n.ProductID}
+	}
+	return InboxItem{
+		ID:        n.ID,
+		Kind:      KindUser,
+		CreatedAt: n.CreatedAt,
+		Read:      n.Read,
+		Actor:     &InboxActor{ID: n.FromID, Name: n.FromName},
+		Target:    target,
+		Payload:   n,
+	}
+}
+
+// InboxItemFromOwner wraps a product owner notification, targeting the new
+// review when known and the product otherwise
+func InboxItemFromOwner(n ProductOwnerNotification) InboxItem {
+	target := &InboxTarget{Type: "product", ID: n.ProductID, ProductID: n.ProductID}
+	if n.ReviewID != nil && *n.ReviewID != "" {
+		target = &InboxTarget{Type: "review", ID: *n.ReviewID, ProductID: n.ProductID}
+	}
+	return InboxItem{
+		ID:        n.ID,
+		Kind:      KindOwner,
+		CreatedAt: n.CreatedAt,
+		Read:      n.Read,
+		Actor:     &InboxActor{ID: n.FromID, Name: n.FromName},
+		Target:    target,
+		Payload:   n,
+	}
+}
+
+// InboxItemFromLike wraps a like notification, targeting the liked comment or review
+func InboxItemFromLike(n LikeNotification) InboxItem {
+	return InboxItem{
+		ID:        n.ID,
+		Kind:      KindLike,
+		CreatedAt: n.CreatedAt,
+		Read:      n.Read,
+		Actor:     &InboxActor{ID: n.FromID, Name: n.FromName},
+		Target:    &InboxTarget{Type: n.TargetType, ID: n.TargetID, ProductID: n.ProductID},
+		Payload:   n,
+	}
+}
+
+// InboxItemFromSystem wraps a system notification, which has no actor or target
+func InboxItemFromSystem(n SystemNotification) InboxItem {
+	return InboxItem{
+		ID:        n.ID,
+		Kind:      KindSystem,
+		CreatedAt: n.CreatedAt,
+		Read:      n.Read,
+		Payload:   n,
+	}
+}
@@ -1,72 +1,94 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The validate tags on the notification and user structs describe what a
+// create request must contain, with lengths matching the database columns.
+// Fields the server fills in, like created_at and read, aren't checked.
 
 // UserNotification represents a notification for a regular user
 type UserNotification struct {
-	ID               string    `db:"id" json:"id"`
-	ParentUserID     string    `db:"parent_user_id" json:"parent_user_id"`
+	ID               string    `db:"id" json:"id" validate:"required,max=255"`
+	ParentUserID     string    `db:"parent_user_id" json:"parent_user_id" validate:"max=255"`
 	Content          string    `db:"content" json:"content"`
 	CreatedAt        time.Time `db:"created_at" json:"created_at"`
 	Read             bool      `db:"read" json:"read"`
 	NotificationType string    `db:"notification_type" json:"notification_type"`
-	CommentID        string    `db:"comment_id" json:"comment_id"`
-	ReviewID         string    `db:"review_id" json:"review_id"`
-	FromID           string    `db:"from_id" json:"from_id"`
-	ParentID         string    `db:"parent_id" json:"parent_id"`
-	FromName         string    `db:"from_name" json:"from_name"`
-	ProductID        string    `db:"product_id" json:"product_id"`
+	CommentID        string    `db:"comment_id" json:"comment_id" validate:"max=255"`
+	ReviewID         string    `db:"review_id" json:"review_id" validate:"max=255"`
+	FromID           string    `db:"from_id" json:"from_id" validate:"required,max=255"`
+	ParentID         string    `db:"parent_id" json:"parent_id" validate:"max=255"`
+	FromName         string    `db:"from_name" json:"from_name" validate:"max=255"`
+	ProductID        string    `db:"product_id" json:"product_id" validate:"max=255"`
 }
 
 // ProductOwnerNotification represents a notification for a product owner
 type ProductOwnerNotification struct {
-	ID               string    `db:"id" json:"id"`
-	OwnerID          string    `db:"owner_id" json:"owner_id"`
-	ProductID        string    `db:"product_id" json:"product_id"`
-	ProductName      string    `db:"product_name" json:"product_name"`
-	BusinessID       string    `db:"business_id" json:"business_id"`
+	ID               string    `db:"id" json:"id" validate:"required,max=255"`
+	OwnerID          string    `db:"owner_id" json:"owner_id" validate:"required,max=255"`
+	ProductID        string    `db:"product_id" json:"product_id" validate:"required,max=255"`
+	ProductName      string    `db:"product_name" json:"product_name" validate:"max=255"`
+	BusinessID       string    `db:"business_id" json:"business_id" validate:"max=255"`
 	ReviewTitle      string    `db:"review_title" json:"review_title"`
 	CreatedAt        time.Time `db:"created_at" json:"created_at"`
-	FromName         string    `db:"from_name" json:"from_name"`
-	FromID           string    `db:"from_id" json:"from_id"`
+	FromName         string    `db:"from_name" json:"from_name" validate:"max=255"`
+	FromID           string    `db:"from_id" json:"from_id" validate:"required,max=255"`
 	Read             bool      `db:"read" json:"read"`
-	CommentID        *string   `db:"comment_id" json:"comment_id"`
-	ReviewID         *string   `db:"review_id" json:"review_id"`
+	CommentID        *string   `db:"comment_id" json:"comment_id" validate:"max=255"`
+	ReviewID         *string   `db:"review_id" json:"review_id" validate:"max=255"`
 	NotificationType string    `db:"notification_type" json:"notification_type"`
 }
 
 // User represents a user in the system
 type User struct {
-	ID       string `db:"id" json:"id"`
-	Username string `db:"username" json:"username"`
-	FullName string `db:"full_name" json:"full_name"`
+	ID       string `db:"id" json:"id" validate:"required,max=255"`
+	Username string `db:"username" json:"username" validate:"max=255"`
+	FullName string `db:"full_name" json:"full_name" validate:"max=255"`
 }
 
 // LikeNotification represents a like notification
 type LikeNotification struct {
 	ID           string    `db:"id" json:"id"`
-	TargetUserID string    `db:"target_user_id" json:"target_user_id"` // User who owns the liked content
-	TargetType   string    `db:"target_type" json:"target_type"`       // "comment" or "review"
-	TargetID     string    `db:"target_id" json:"target_id"`           // ID of the liked content
-	FromID       string    `db:"from_id" json:"from_id"`               // User who liked
-	FromName     string    `db:"from_name" json:"from_name"`           // Name of user who liked
-	ProductID    string    `db:"product_id" json:"product_id"`         // Product context
+	TargetUserID string    `db:"target_user_id" json:"target_user_id"`                                    // User who owns the liked content
+	TargetType   string    `db:"target_type" json:"target_type" validate:"required,oneof=comment review"` // "comment" or "review"
+	TargetID     string    `db:"target_id" json:"target_id" validate:"required,max=255"`                  // ID of the liked content
+	FromID       string    `db:"from_id" json:"from_id" validate:"required,max=255"`                      // User who liked
+	FromName     string    `db:"from_name" json:"from_name" validate:"max=255"`                           // Name of user who liked
+	ProductID    string    `db:"product_id" json:"product_id" validate:"max=255"`                         // Product context
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
 	Read         bool      `db:"read" json:"read"`
 }
 
 // SystemNotification represents a system/admin notification
 type SystemNotification struct {
-	ID              string    `db:"id" json:"id"`
-	TargetUserIDs   string    `db:"target_user_ids" json:"-"` // Comma-separated user IDs
-	TargetUserIDsArray []string `json:"target_user_ids"`      // JSON representation
-	Title           string    `db:"title" json:"title"`
-	Message         string    `db:"message" json:"message"`
-	CtaURL          *string   `db:"cta_url" json:"cta_url"`           // Optional call-to-action URL
-	Icon            *string   `db:"icon" json:"icon"`                 // Optional icon hint (info/success/warning/error)
-	Read            bool      `db:"read" json:"read"`
-	CreatedAt       time.Time `db:"created_at" json:"created_at"`
-	NotificationType string   `db:"notification_type" json:"notification_type"` // Always "system"
+	ID               string         `db:"id" json:"id" validate:"required,max=255"`
+	TargetUserIDs    pq.StringArray `db:"target_user_ids" json:"target_user_ids" validate:"dive,max=255"` // Recipients, empty means broadcast to all
+	Title            string         `db:"title" json:"title" validate:"required,max=255"`
+	Message          string         `db:"message" json:"message" validate:"required"`
+	CtaURL           *string        `db:"cta_url" json:"cta_url" validate:"max=500"` // Optional call-to-action URL
+	Icon             *string        `db:"icon" json:"icon" validate:"max=50"`        // Optional icon hint (info/success/warning/error)
+	Read             bool           `db:"read" json:"read"`                          // Read state for the requesting user
+	CreatedAt        time.Time      `db:"created_at" json:"created_at"`
+	NotificationType string         `db:"notification_type" json:"notification_type"` // Always "system"
+}
+
+// NotificationCounts holds notification totals per kind, e.g. a user's
+// unread counts or how many notifications a bulk operation changed
+type NotificationCounts struct {
+	User   int `db:"user_count" json:"user"`
+	Owner  int `db:"owner_count" json:"owner"`
+	Like   int `db:"like_count" json:"like"`
+	System int `db:"system_count" json:"system"`
+	Total  int `db:"-" json:"total"`
+}
+
+// Sum sets Total from the per-kind counts
+func (c *NotificationCounts) Sum() {
+	c.Total = c.User + c.Owner + c.Like + c.System
 }
 
 // NotificationMessage represents a message sent through SSE
@@ -75,4 +97,12 @@ type NotificationMessage struct {
 	Type         string      `json:"type"` // "user", "owner", "like", or "system"
 	Notification interface{} `json:"notification"`
 	Event        string      `json:"event"` // "new_notification", "notification_read", etc.
-}
\ No newline at end of file
+}
+
+// Notification kinds, used as the SSE message type and the ?type= parameter
+const (
+	KindUser   = "user"
+	KindOwner  = "owner"
+	KindLike   = "like"
+	KindSystem = "system"
+)
@@ -0,0 +1,45 @@
+package openapi
+
+import (
+	_ "embed"
+	"html"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DocsTag tags the documentation's own routes
+const DocsTag = "Docs"
+
+//go:embed docs.html
+var docsPage string
+
+// Serve registers the document as JSON at specPath and the docs page, which
+// renders it and can send requests to the API, at docsPath. Both routes are
+// documented too, and routes added after Serve still show up.
+func (r *Registry) Serve(specPath string, docsPath string) {
+	r.Add(Route{
+		Method:    fiber.MethodGet,
+		Path:      specPath,
+		Summary:   "OpenAPI document",
+		Tags:      []string{DocsTag},
+		Responses: []Reply{{Description: "This document", Body: map[string]interface{}{}}},
+		Handlers: []fiber.Handler{func(c *fiber.Ctx) error {
+			return c.JSON(r.doc)
+		}},
+	})
+
+	page := strings.ReplaceAll(docsPage, "{{SPEC_URL}}", html.EscapeString(specPath))
+	page = strings.ReplaceAll(page, "{{TITLE}}", html.EscapeString(r.doc.Info.Title))
+	r.Add(Route{
+		Method:    fiber.MethodGet,
+		Path:      docsPath,
+		Summary:   "Interactive API docs",
+		Tags:      []string{DocsTag},
+		Responses: []Reply{{Description: "HTML docs page", ContentType: fiber.MIMETextHTMLCharsetUTF8}},
+		Handlers: []fiber.Handler{func(c *fiber.Ctx) error {
+			c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+			return c.SendString(page)
+		}},
+	})
+}
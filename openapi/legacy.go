@@ -0,0 +1,48 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Legacy describes the unversioned aliases a route group keeps for clients
+// that predate it. Responses from an alias carry Deprecation, Sunset and Link
+// headers (RFC 9745 and RFC 8594) pointing clients at the versioned route.
+type Legacy struct {
+	// Deprecated is when the aliases were deprecated; zero sends "Deprecation: true"
+	Deprecated time.Time
+	// Sunset is when the aliases will be removed; zero sends no Sunset header
+	Sunset time.Time
+	// Link is an optional migration guide, sent as a rel="deprecation" link
+	Link string
+}
+
+// handler sets the deprecation headers on responses from an alias of a route
+// in the group at prefix
+func (l *Legacy) handler(prefix string) fiber.Handler {
+	deprecation := "true"
+	if !l.Deprecated.IsZero() {
+		deprecation = fmt.Sprintf("@%d", l.Deprecated.Unix())
+	}
+	sunset := ""
+	if !l.Sunset.IsZero() {
+		sunset = l.Sunset.UTC().Format(http.TimeFormat)
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Set("Deprecation", deprecation)
+		if sunset != "" {
+			c.Set("Sunset", sunset)
+		}
+		links := []string{fmt.Sprintf(`<%s%s>; rel="successor-version"`, prefix, c.Path())}
+		if l.Link != "" {
+			links = append(links, fmt.Sprintf(`<%s>; rel="deprecation"`, l.Link))
+		}
+		c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+		return c.Next()
+	}
+}
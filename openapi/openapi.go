@@ -0,0 +1,330 @@
+// Package openapi documents the API as it is registered. Routes are added
+// through a Registry, which mounts them on Fiber and records an OpenAPI 3
+// operation for each, with schemas generated from the Go types of their
+// request and response bodies. The document is served as JSON together with
+// a bundled docs page.
+package openapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Version is the OpenAPI version of generated documents
+const Version = "3.0.3"
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Tags       []Tag               `json:"tags,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Tag groups operations in the docs
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of one path, keyed by lower case method
+type PathItem map[string]*Operation
+
+// Components holds the schemas referenced by operations
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Operation is one method on one path
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes what an operation accepts
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes one response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the body of a request or response in one content type
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Route describes a route to register and document. Path uses Fiber's
+// syntax; its :params are documented as path parameters.
+type Route struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	// Params are the query and header parameters
+	Params []Param
+	// Body is a value of the JSON request body's type, nil for none
+	Body      interface{}
+	Responses []Reply
+	Handlers  []fiber.Handler
+	// NoAlias skips the unversioned alias in a group with Legacy aliases,
+	// for routes that never existed without the version
+	NoAlias bool
+}
+
+// Param describes a query or header parameter
+type Param struct {
+	Name        string
+	In          string // "query" (the default) or "header"
+	Description string
+	Required    bool
+	Type        string // "string" (the default), "integer" or "boolean"
+	Enum        []string
+}
+
+// Reply describes one success response of a route
+type Reply struct {
+	Status      int
+	Description string
+	// Body is a value of the response body's type, nil for none
+	Body interface{}
+	// ContentType defaults to application/json
+	ContentType string
+}
+
+// Query returns an optional query parameter
+func Query(name string, description string) Param {
+	return Param{Name: name, In: "query", Description: description}
+}
+
+// RequiredQuery returns a required query parameter
+func RequiredQuery(name string, description string) Param {
+	return Param{Name: name, In: "query", Description: description, Required: true}
+}
+
+// Header returns an optional header parameter
+func Header(name string, description string) Param {
+	return Param{Name: name, In: "header", Description: description}
+}
+
+// Registry registers routes on a Fiber router and documents them
+type Registry struct {
+	router  fiber.Router
+	doc     *Document
+	schemas *schemaSet
+	// errorSchema documents every operation's error responses
+	errorSchema *Schema
+	// prefix is prepended to the paths of routes added to a group
+	prefix string
+	// legacy, when set, also mounts each route of a group at its unversioned path
+	legacy *Legacy
+}
+
+// NewRegistry returns a registry adding routes to router. errorBody is a
+// value of the type every error response has.
+func NewRegistry(router fiber.Router, info Info, errorBody interface{}) *Registry {
+	schemas := newSchemaSet()
+	return &Registry{
+		router: router,
+		doc: &Document{
+			OpenAPI:    Version,
+			Info:       info,
+			Paths:      make(map[string]PathItem),
+			Components: Components{Schemas: schemas.components},
+		},
+		schemas:     schemas,
+		errorSchema: schemas.of(errorBody),
+	}
+}
+
+// AddTag describes a tag used by the registered routes
+func (r *Registry) AddTag(name string, description string) {
+	r.doc.Tags = append(r.doc.Tags, Tag{Name: name, Description: description})
+}
+
+// Group returns a registry that adds routes under prefix, e.g. /v1, sharing
+// this registry's document. With legacy set, every route is also mounted at
+// its path without the prefix as a deprecated alias; see Legacy.
+func (r *Registry) Group(prefix string, legacy *Legacy) *Registry {
+	group := *r
+	group.prefix = r.prefix + prefix
+	group.legacy = legacy
+	return &group
+}
+
+// Add registers a route and documents it. GET routes also answer HEAD, as
+// with fiber.Router.Get.
+func (r *Registry) Add(route Route) {
+	unversioned := route.Path
+	route.Path = r.prefix + unversioned
+	r.mount(route)
+
+	if r.legacy != nil && !route.NoAlias {
+		alias := route
+		alias.Path = unversioned
+		alias.Handlers = append([]fiber.Handler{r.legacy.handler(r.prefix)}, route.Handlers...)
+		r.mount(alias)
+
+		op := r.doc.Paths[Path(alias.Path)][strings.ToLower(alias.Method)]
+		op.Deprecated = true
+		op.OperationID = "legacy_" + op.OperationID
+		op.Description = strings.TrimSpace("Deprecated alias of " + Path(route.Path) + ". " + op.Description)
+	}
+}
+
+// mount adds a route to the router and the document
+func (r *Registry) mount(route Route) {
+	if route.Method == fiber.MethodGet {
+		r.router.Get(route.Path, route.Handlers...)
+	} else {
+		r.router.Add(route.Method, route.Path, route.Handlers...)
+	}
+
+	path := Path(route.Path)
+	item := r.doc.Paths[path]
+	if item == nil {
+		item = make(PathItem)
+		r.doc.Paths[path] = item
+	}
+	item[strings.ToLower(route.Method)] = r.operation(route)
+}
+
+// Document returns the document of every route added so far
+func (r *Registry) Document() *Document {
+	return r.doc
+}
+
+// Operation returns the documented operation for a method and Fiber path, or nil
+func (r *Registry) Operation(method string, path string) *Operation {
+	return r.doc.Paths[Path(path)][strings.ToLower(method)]
+}
+
+// operation builds the documentation of a route
+func (r *Registry) operation(route Route) *Operation {
+	op := &Operation{
+		OperationID: operationID(route.Method, route.Path),
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        route.Tags,
+		Responses:   make(map[string]Response),
+	}
+
+	for _, segment := range strings.Split(route.Path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     strings.TrimSuffix(segment[1:], "?"),
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+	}
+	for _, param := range route.Params {
+		in := param.In
+		if in == "" {
+			in = "query"
+		}
+		schema := &Schema{Type: param.Type, Enum: param.Enum}
+		if schema.Type == "" {
+			schema.Type = "string"
+		}
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        param.Name,
+			In:          in,
+			Description: param.Description,
+			Required:    param.Required,
+			Schema:      schema,
+		})
+	}
+
+	if route.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{fiber.MIMEApplicationJSON: {Schema: r.schemas.of(route.Body)}},
+		}
+	}
+
+	for _, reply := range route.Responses {
+		status := reply.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		description := reply.Description
+		if description == "" {
+			description = http.StatusText(status)
+		}
+		response := Response{Description: description}
+		contentType := reply.ContentType
+		if contentType == "" {
+			contentType = fiber.MIMEApplicationJSON
+		}
+		if reply.Body != nil {
+			response.Content = map[string]MediaType{contentType: {Schema: r.schemas.of(reply.Body)}}
+		} else if reply.ContentType != "" {
+			response.Content = map[string]MediaType{contentType: {Schema: &Schema{Type: "string"}}}
+		}
+		op.Responses[strconv.Itoa(status)] = response
+	}
+	op.Responses["default"] = Response{
+		Description: "Error",
+		Content:     map[string]MediaType{fiber.MIMEApplicationJSON: {Schema: r.errorSchema}},
+	}
+	return op
+}
+
+// Path converts a Fiber route path to an OpenAPI path, e.g.
+// /notifications/:id to /notifications/{id}
+func Path(fiberPath string) string {
+	segments := strings.Split(fiberPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + strings.TrimSuffix(segment[1:], "?") + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// operationID derives a stable operation ID from a method and Fiber path,
+// e.g. put_notifications_id_read
+func operationID(method string, fiberPath string) string {
+	parts := []string{strings.ToLower(method)}
+	for _, segment := range strings.Split(fiberPath, "/") {
+		segment = strings.Trim(segment, ":?")
+		segment = strings.NewReplacer("-", "_", ".", "_").Replace(segment)
+		if segment != "" {
+			parts = append(parts, segment)
+		}
+	}
+	return strings.Join(parts, "_")
+}
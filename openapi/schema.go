@@ -0,0 +1,201 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema is a JSON schema as used by OpenAPI 3.0
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Nullable    bool               `json:"nullable,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	MinItems    *int               `json:"minItems,omitempty"`
+	MaxItems    *int               `json:"maxItems,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// AdditionalProperties describes the values of a map
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaSet generates schemas from Go types. Named structs become shared
+// components referenced by $ref.
+type schemaSet struct {
+	components map[string]*Schema
+	// names maps each struct type already seen to its component name
+	names map[reflect.Type]string
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{
+		components: make(map[string]*Schema),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+// of returns the schema of v's type
+func (s *schemaSet) of(v interface{}) *Schema {
+	return s.forType(reflect.TypeOf(v))
+}
+
+// forType returns the schema of a type, registering struct components as needed
+func (s *schemaSet) forType(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawJSONType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.forType(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: s.forType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.forType(t.Elem())}
+	case reflect.Struct:
+		return s.structRef(t)
+	default:
+		// interface{} and anything else can hold any JSON value
+		return &Schema{}
+	}
+}
+
+// structRef returns a reference to the component of a struct type
+func (s *schemaSet) structRef(t reflect.Type) *Schema {
+	name, ok := s.names[t]
+	if !ok {
+		name = componentName(t)
+		for i := 2; s.components[name] != nil; i++ {
+			name = componentName(t) + strconv.Itoa(i)
+		}
+		s.names[t] = name
+		// Reserve the name first so self-referencing types terminate
+		s.components[name] = &Schema{}
+		*s.components[name] = *s.structSchema(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// structSchema describes a struct's JSON fields, applying their validate tags
+func (s *schemaSet) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if embedded := field.Type; field.Anonymous && name == "" {
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				// encoding/json promotes an embedded struct's fields
+				promoted := s.structSchema(embedded)
+				for name, property := range promoted.Properties {
+					schema.Properties[name] = property
+				}
+				schema.Required = append(schema.Required, promoted.Required...)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := s.forType(field.Type)
+		if applyRules(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// applyRules adds the constraints of a validate tag to a property's schema
+// and reports whether the property is required
+func applyRules(schema *Schema, tag string) bool {
+	if tag == "" || tag == "-" {
+		return false
+	}
+
+	required := false
+	target := schema
+	for _, rule := range strings.Split(tag, ",") {
+		rule, param, _ := strings.Cut(rule, "=")
+		switch rule {
+		case "dive":
+			if target.Items == nil {
+				return required
+			}
+			target = target.Items
+		case "required":
+			if target == schema {
+				required = true
+			}
+			if target.Type == "array" {
+				one := 1
+				target.MinItems = &one
+			}
+		case "max":
+			limit, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			if target.Type == "array" {
+				target.MaxItems = &limit
+			} else {
+				target.MaxLength = &limit
+			}
+		case "oneof":
+			target.Enum = strings.Fields(param)
+		}
+	}
+	return required
+}
+
+// componentName returns the schema name of a struct type, e.g. UserNotification
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return "Object"
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
@@ -0,0 +1,255 @@
+package retention
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/models"
+)
+
+// Modes for what happens to notifications that fall outside a rule
+const (
+	ModePurge   = "purge"
+	ModeArchive = "archive"
+)
+
+// Rule describes how long notifications of one kind are kept. Zero values
+// disable that part of the rule.
+type Rule struct {
+	Kind         string
+	ReadMaxAge   time.Duration
+	UnreadMaxAge time.Duration
+	MaxPerUser   int
+}
+
+// Config controls the retention worker
+type Config struct {
+	Rules    []Rule
+	Mode     string        // ModePurge or ModeArchive
+	Interval time.Duration // how often the worker runs
+	DryRun   bool          // report what would be removed without removing it
+}
+
+// Report summarises one retention run
+type Report struct {
+	StartedAt  time.Time                  `json:"started_at"`
+	FinishedAt time.Time                  `json:"finished_at"`
+	DryRun     bool                       `json:"dry_run"`
+	Mode       string                     `json:"mode"`
+	Results    []database.RetentionResult `json:"results"`
+	Total      int64                      `json:"total"`
+	// ExpiredIdempotencyKeys counts idempotency keys past their TTL
+	ExpiredIdempotencyKeys int64    `json:"expired_idempotency_keys"`
+	Errors                 []string `json:"errors,omitempty"`
+}
+
+// Worker periodically applies the retention rules to the store
+type Worker struct {
+	store  database.Store
+	config Config
+	now    func() time.Time
+	stop   chan struct{}
+	once   sync.Once
+	mutex  sync.Mutex
+	last   *Report
+}
+
+// NewWorker creates a retention worker; call Run to start it
+func NewWorker(store database.Store, config Config) *Worker {
+	if config.Mode == "" {
+		config.Mode = ModePurge
+	}
+	if config.Interval <= 0 {
+		config.Interval = time.Hour
+	}
+	return &Worker{
+		store:  store,
+		config: config,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Run applies the rules once immediately and then every Interval until Stop is called
+func (w *Worker) Run() {
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		report := w.RunOnce(w.config.DryRun)
+		logReport(report)
+
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// DryRun reports whether the worker is configured to only report what it
+// would remove (RETENTION_DRY_RUN)
+func (w *Worker) DryRun() bool {
+	return w.config.DryRun
+}
+
+// Stop ends the Run loop after the current pass
+func (w *Worker) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// RunOnce applies every rule and returns the report. Runs don't overlap.
+func (w *Worker) RunOnce(dryRun bool) *Report {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	report := &Report{
+		StartedAt: w.now(),
+		DryRun:    dryRun,
+		Mode:      w.config.Mode,
+		Results:   make([]database.RetentionResult, 0, len(w.config.Rules)),
+	}
+
+	for _, rule := range w.config.Rules {
+		result, err := w.store.PurgeNotifications(w.policyFor(rule, report.StartedAt), dryRun)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", rule.Kind, err))
+			continue
+		}
+		report.Results = append(report.Results, *result)
+		report.Total += result.Total()
+	}
+
+	// Expired idempotency keys are always removed, whatever the rules say
+	expired, err := w.store.PurgeIdempotencyKeys(dryRun)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("idempotency keys: %v", err))
+	}
+	report.ExpiredIdempotencyKeys = expired
+
+	report.FinishedAt = w.now()
+	w.last = report
+	return report
+}
+
+// LastReport returns the most recent run's report, or nil before the first run
+func (w *Worker) LastReport() *Report {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.last
+}
+
+// policyFor turns a rule's ages into absolute cutoffs relative to now. The
+// cutoffs are in UTC, as the stored timestamps are.
+func (w *Worker) policyFor(rule Rule, now time.Time) database.RetentionPolicy {
+	now = now.UTC()
+	policy := database.RetentionPolicy{
+		Kind:       rule.Kind,
+		MaxPerUser: rule.MaxPerUser,
+		Archive:    w.config.Mode == ModeArchive,
+	}
+	if rule.ReadMaxAge > 0 {
+		cutoff := now.Add(-rule.ReadMaxAge)
+		policy.ReadBefore = &cutoff
+	}
+	if rule.UnreadMaxAge > 0 {
+		cutoff := now.Add(-rule.UnreadMaxAge)
+		policy.UnreadBefore = &cutoff
+	}
+	return policy
+}
+
+func logReport(report *Report) {
+	prefix := "Retention"
+	if report.DryRun {
+		prefix = "Retention (dry run)"
+	}
+	for _, result := range report.Results {
+		log.Printf("%s %s: read_expired=%d unread_expired=%d over_limit=%d",
+			prefix, result.Kind, result.ReadExpired, result.UnreadExpired, result.OverLimit)
+	}
+	for _, e := range report.Errors {
+		log.Printf("%s error: %s", prefix, e)
+	}
+	if report.ExpiredIdempotencyKeys > 0 {
+		log.Printf("%s idempotency keys: expired=%d", prefix, report.ExpiredIdempotencyKeys)
+	}
+	log.Printf("%s finished in %s, %d notifications %sd", prefix,
+		report.FinishedAt.Sub(report.StartedAt), report.Total, report.Mode)
+}
+
+// ConfigFromEnv builds a Config from RETENTION_* environment variables:
+//
+//	RETENTION_INTERVAL              Go duration between runs (default 1h)
+//	RETENTION_MODE                  purge or archive (default purge)
+//	RETENTION_DRY_RUN               true to only report
+//	RETENTION_<KIND>_READ_DAYS      remove read notifications older than N days
+//	RETENTION_<KIND>_UNREAD_DAYS    remove unread notifications older than N days
+//	RETENTION_<KIND>_MAX_PER_USER   keep only the newest N per user
+//
+// where KIND is USER, OWNER, LIKE or SYSTEM. Kinds with no settings get no rule.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		Mode:     ModePurge,
+		Interval: time.Hour,
+		DryRun:   os.Getenv("RETENTION_DRY_RUN") == "true",
+	}
+
+	if v := os.Getenv("RETENTION_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			return config, fmt.Errorf("invalid RETENTION_INTERVAL %q", v)
+		}
+		config.Interval = interval
+	}
+
+	if v := os.Getenv("RETENTION_MODE"); v != "" {
+		if v != ModePurge && v != ModeArchive {
+			return config, fmt.Errorf("RETENTION_MODE must be %q or %q", ModePurge, ModeArchive)
+		}
+		config.Mode = v
+	}
+
+	for _, kind := range []string{models.KindUser, models.KindOwner, models.KindLike, models.KindSystem} {
+		prefix := "RETENTION_" + strings.ToUpper(kind) + "_"
+		rule := Rule{Kind: kind}
+		var err error
+
+		if rule.ReadMaxAge, err = envDays(prefix + "READ_DAYS"); err != nil {
+			return config, err
+		}
+		if rule.UnreadMaxAge, err = envDays(prefix + "UNREAD_DAYS"); err != nil {
+			return config, err
+		}
+		if v := os.Getenv(prefix + "MAX_PER_USER"); v != "" {
+			if rule.MaxPerUser, err = strconv.Atoi(v); err != nil || rule.MaxPerUser < 0 {
+				return config, fmt.Errorf("invalid %sMAX_PER_USER %q", prefix, v)
+			}
+		}
+
+		if rule.ReadMaxAge > 0 || rule.UnreadMaxAge > 0 || rule.MaxPerUser > 0 {
+			config.Rules = append(config.Rules, rule)
+		}
+	}
+
+	return config, nil
+}
+
+// envDays reads a whole number of days from the environment as a duration
+func envDays(name string) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
@@ -0,0 +1,58 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktappdev/noti-service/database"
+	"github.com/ktappdev/noti-service/models"
+)
+
+// recordingStore records the policies the worker applies
+type recordingStore struct {
+	database.Store
+	policies []database.RetentionPolicy
+}
+
+func (s *recordingStore) PurgeNotifications(policy database.RetentionPolicy, dryRun bool) (*database.RetentionResult, error) {
+	s.policies = append(s.policies, policy)
+	return &database.RetentionResult{Kind: policy.Kind}, nil
+}
+
+func (s *recordingStore) PurgeIdempotencyKeys(dryRun bool) (int64, error) {
+	return 0, nil
+}
+
+func TestRunOnceCutoffsAreUTC(t *testing.T) {
+	store := &recordingStore{}
+	worker := NewWorker(store, Config{Rules: []Rule{
+		{Kind: models.KindUser, ReadMaxAge: time.Hour, UnreadMaxAge: 2 * time.Hour},
+	}})
+	// A server whose local zone is five hours behind UTC
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	worker.now = func() time.Time { return now }
+
+	report := worker.RunOnce(true)
+	if len(report.Errors) > 0 {
+		t.Fatalf("RunOnce errors: %v", report.Errors)
+	}
+	if len(store.policies) != 1 {
+		t.Fatalf("got %d policies, want 1", len(store.policies))
+	}
+
+	policy := store.policies[0]
+	for name, tc := range map[string]struct {
+		got  *time.Time
+		want time.Time
+	}{
+		"read":   {policy.ReadBefore, time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)},
+		"unread": {policy.UnreadBefore, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+	} {
+		if tc.got == nil {
+			t.Fatalf("%s cutoff not set", name)
+		}
+		if tc.got.Location() != time.UTC || !tc.got.Equal(tc.want) {
+			t.Errorf("%s cutoff = %s, want %s", name, tc.got, tc.want)
+		}
+	}
+}
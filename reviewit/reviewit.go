@@ -1,6 +1,8 @@
 package reviewit
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 
@@ -8,11 +10,17 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// ErrNotConfigured is returned when REVIEWIT_DATABASE_URL isn't set
+var ErrNotConfigured = errors.New("REVIEWIT_DATABASE_URL environment variable is required")
+
+// ErrNotFound is returned when the comment or review doesn't exist in ReviewIt
+var ErrNotFound = errors.New("not found in ReviewIt")
+
 // GetParentCommentUserID retrieves the userId of the user who made the parent comment
 func GetParentCommentUserID(parentID string) (string, error) {
 	dbURL := os.Getenv("REVIEWIT_DATABASE_URL")
 	if dbURL == "" {
-		return "", fmt.Errorf("REVIEWIT_DATABASE_URL environment variable is required")
+		return "", ErrNotConfigured
 	}
 	// Connect to the database
 	db, err := sqlx.Connect("postgres", dbURL)
@@ -31,6 +39,9 @@ func GetParentCommentUserID(parentID string) (string, error) {
 
 	var userID string
 	err = db.Get(&userID, query, parentID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("comment %s: %w", parentID, ErrNotFound)
+	}
 	if err != nil {
 		return "", fmt.Errorf("error querying database: %w", err)
 	}
@@ -43,7 +54,7 @@ func GetParentCommentUserID(parentID string) (string, error) {
 func GetCommentUserID(commentID string) (string, error) {
 	dbURL := os.Getenv("REVIEWIT_DATABASE_URL")
 	if dbURL == "" {
-		return "", fmt.Errorf("REVIEWIT_DATABASE_URL environment variable is required")
+		return "", ErrNotConfigured
 	}
 	// Connect to the database
 	db, err := sqlx.Connect("postgres", dbURL)
@@ -61,6 +72,9 @@ func GetCommentUserID(commentID string) (string, error) {
 
 	var userID string
 	err = db.Get(&userID, query, commentID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("comment %s: %w", commentID, ErrNotFound)
+	}
 	if err != nil {
 		return "", fmt.Errorf("error querying database: %w", err)
 	}
@@ -72,7 +86,7 @@ func GetCommentUserID(commentID string) (string, error) {
 func GetReviewUserID(reviewID string) (string, error) {
 	dbURL := os.Getenv("REVIEWIT_DATABASE_URL")
 	if dbURL == "" {
-		return "", fmt.Errorf("REVIEWIT_DATABASE_URL environment variable is required")
+		return "", ErrNotConfigured
 	}
 	// Connect to the database
 	db, err := sqlx.Connect("postgres", dbURL)
@@ -90,6 +104,9 @@ func GetReviewUserID(reviewID string) (string, error) {
 
 	var userID string
 	err = db.Get(&userID, query, reviewID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("review %s: %w", reviewID, ErrNotFound)
+	}
 	if err != nil {
 		return "", fmt.Errorf("error querying database: %w", err)
 	}
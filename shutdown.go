@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ktappdev/noti-service/sse"
+)
+
+// defaultShutdownTimeout is how long shutdown may take when SHUTDOWN_TIMEOUT
+// isn't set
+const defaultShutdownTimeout = 20 * time.Second
+
+// shutdownTimeoutFromEnv reads SHUTDOWN_TIMEOUT, a duration such as "30s"
+func shutdownTimeoutFromEnv() (time.Duration, error) {
+	v := os.Getenv("SHUTDOWN_TIMEOUT")
+	if v == "" {
+		return defaultShutdownTimeout, nil
+	}
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q: must be a positive duration", v)
+	}
+	return timeout, nil
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, then shuts down gracefully:
+// SSE streams are ended with server_shutdown, requests in flight finish, the
+// hub delivers what they queued, and teardown closes everything else. If all
+// that takes longer than timeout the process exits anyway.
+func waitForShutdown(app *fiber.App, hub *sse.SSEHub, timeout time.Duration, teardown func()) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	sig := <-quit
+	signal.Stop(quit)
+
+	log.Printf("Received %s, shutting down (deadline %s)", sig, timeout)
+	deadline := time.AfterFunc(timeout, func() {
+		log.Printf("Shutdown took longer than %s, exiting", timeout)
+		os.Exit(1)
+	})
+	defer deadline.Stop()
+
+	// Requests get three quarters of the deadline, leaving the rest for
+	// the hub and teardown
+	ctx, cancel := context.WithTimeout(context.Background(), timeout*3/4)
+	defer cancel()
+
+	// Streams hold their connections open, so they have to end before the
+	// server can finish shutting down
+	hub.Shutdown()
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		log.Printf("Error waiting for requests to finish: %v", err)
+	}
+	hub.Close()
+	teardown()
+	log.Printf("Shutdown complete")
+}
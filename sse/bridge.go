@@ -0,0 +1,179 @@
+package sse
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Bridge relays hub events between instances of the service, so a user gets
+// an event whichever instance their stream is connected to
+type Bridge interface {
+	// Publish sends an event this instance broadcast to the other instances.
+	// It must not block the hub.
+	Publish(event Event)
+}
+
+// Event is a broadcast as it travels between instances
+type Event struct {
+	// Instance is the hub that broadcast the event
+	Instance string `json:"instance"`
+	ID       int64  `json:"id"`
+	// UserID is empty for a broadcast to all, which each instance fans out
+	// to its own clients under its own IDs
+	UserID string          `json:"user_id"`
+	Event  string          `json:"event"`
+	Data   json.RawMessage `json:"data"`
+	// Oversize marks an event whose data was too large to relay. It carries
+	// no data; receivers resync the user's clients instead.
+	Oversize bool `json:"oversize,omitempty"`
+}
+
+// maxNotifyPayload is the most Postgres accepts in one NOTIFY
+const maxNotifyPayload = 7999
+
+// PostgresBridge relays hub events over Postgres LISTEN/NOTIFY. Every
+// instance publishes to and listens on the same channel.
+type PostgresBridge struct {
+	db      *sqlx.DB
+	connStr string
+	channel string
+	hub     *SSEHub
+	queue   chan []byte
+	stop    chan struct{}
+	once    sync.Once
+	// publishing tracks the publish goroutine, which Stop waits for
+	publishing sync.WaitGroup
+}
+
+// NewPostgresBridge creates a bridge for hub and sets it on the hub; call Run
+// to start it. connStr is used for the dedicated LISTEN connection.
+func NewPostgresBridge(db *sqlx.DB, connStr string, channel string, hub *SSEHub) *PostgresBridge {
+	b := &PostgresBridge{
+		db:      db,
+		connStr: connStr,
+		channel: channel,
+		hub:     hub,
+		queue:   make(chan []byte, 1024),
+		stop:    make(chan struct{}),
+	}
+	hub.SetBridge(b)
+	return b
+}
+
+// Publish queues an event for the other instances. Events that don't fit in
+// a NOTIFY are published without their data, marked Oversize. Events that
+// arrive while the queue is full only reach this instance.
+func (b *PostgresBridge) Publish(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling SSE event %d for the bridge: %v", event.ID, err)
+		return
+	}
+	if len(payload) > maxNotifyPayload {
+		log.Printf("SSE event %d (%s for user %s) is %d bytes, too large to publish to other instances; they will resync the user instead",
+			event.ID, event.Event, event.UserID, len(payload))
+		event.Data = nil
+		event.Oversize = true
+		payload, _ = json.Marshal(event)
+	}
+
+	select {
+	case b.queue <- payload:
+	default:
+		log.Printf("SSE bridge queue is full, event %d for user %s only reaches this instance", event.ID, event.UserID)
+	}
+}
+
+// Run publishes queued events and delivers the other instances' events to
+// the hub until Stop is called. The listener reconnects on its own; events
+// sent while it was down are lost, so the hub is told to resync clients
+// resuming from before the reconnect.
+func (b *PostgresBridge) Run() {
+	listener := pq.NewListener(b.connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Printf("SSE bridge lost its Postgres connection: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Printf("SSE bridge reconnected to Postgres")
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("SSE bridge failed to connect to Postgres: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	b.publishing.Add(1)
+	go b.publish()
+
+	if err := listener.Listen(b.channel); err != nil {
+		log.Printf("SSE bridge failed to listen on %s: %v", b.channel, err)
+		return
+	}
+	log.Printf("SSE bridge listening on %s", b.channel)
+
+	ping := time.NewTicker(time.Minute)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n := <-listener.Notify:
+			if n == nil {
+				// Sent after a reconnect
+				b.hub.MarkGap()
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				log.Printf("Ignoring malformed SSE bridge event: %v", err)
+				continue
+			}
+			b.hub.Receive(event)
+
+		case <-ping.C:
+			// Notices a dead connection that would otherwise go unnoticed
+			go listener.Ping()
+
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// publish sends queued events until Stop is called, then sends what is left
+func (b *PostgresBridge) publish() {
+	defer b.publishing.Done()
+	for {
+		select {
+		case payload := <-b.queue:
+			b.notify(payload)
+		case <-b.stop:
+			for {
+				select {
+				case payload := <-b.queue:
+					b.notify(payload)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// notify sends one event to the other instances
+func (b *PostgresBridge) notify(payload []byte) {
+	if _, err := b.db.Exec(`SELECT pg_notify($1, $2)`, b.channel, string(payload)); err != nil {
+		log.Printf("Error publishing SSE event to other instances: %v", err)
+	}
+}
+
+// Stop stops the bridge once the events already queued have been published.
+// Call it after the hub's Close so events sent while shutting down still
+// reach the other instances.
+func (b *PostgresBridge) Stop() {
+	b.once.Do(func() { close(b.stop) })
+	b.publishing.Wait()
+}
@@ -0,0 +1,63 @@
+package sse
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPublishOversizeEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string // empty for a broadcast to all
+	}{
+		{"to a user", "u1"},
+		{"to all", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewSSEHubWithConfig(DefaultConfig())
+			go hub.Run()
+			defer hub.Close()
+			client := hub.NewClient("u1", FormatEvents)
+			hub.RegisterClient(client)
+			before := hub.nextEventID()
+
+			// Another instance sends an event too large for a NOTIFY
+			id := before + 1000
+			bridge := &PostgresBridge{queue: make(chan []byte, 1)}
+			data, _ := json.Marshal(map[string]string{"message": strings.Repeat("x", maxNotifyPayload)})
+			bridge.Publish(Event{Instance: "other", ID: id, UserID: tt.userID, Event: "new_notification", Data: data})
+
+			var payload []byte
+			select {
+			case payload = <-bridge.queue:
+			default:
+				t.Fatal("oversize event wasn't published")
+			}
+			if len(payload) > maxNotifyPayload {
+				t.Fatalf("published %d bytes, more than a NOTIFY takes", len(payload))
+			}
+			var event Event
+			if err := json.Unmarshal(payload, &event); err != nil {
+				t.Fatal(err)
+			}
+			if !event.Oversize || event.ID != id || event.UserID != tt.userID || !bytes.Equal(event.Data, []byte("null")) {
+				t.Fatalf("published %s, want an oversize marker without data", payload)
+			}
+
+			// This instance resyncs the user's clients and won't replay past
+			// the event
+			hub.Receive(event)
+			if frame := nextFrame(t, client); !bytes.Contains(frame, []byte("event: resync_required")) {
+				t.Errorf("got %q, want resync_required", frame)
+			}
+			if _, complete := hub.ResumeClient(hub.NewClient("u1", FormatEvents), strconv.FormatInt(before, 10)); complete {
+				t.Error("resume from before the oversize event was complete")
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package sse
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+// broadcastBatchSize is how many users a broadcast to all delivers to each
+// time it takes a shard's lock
+const broadcastBatchSize = 500
+
+// BroadcastResult reports who a broadcast to all reached on this instance
+type BroadcastResult struct {
+	// UserIDs are the users the event was delivered to
+	UserIDs []string
+	// Clients counts the streams the event was queued on
+	Clients int
+	// Dropped counts the streams that were too far behind to take it; they
+	// are sent resync_required instead
+	Dropped int
+}
+
+// BroadcastToAll sends an event to every connected client, e.g. for a system
+// announcement. Each shard delivers in batches from a snapshot of who is
+// connected, releasing its lock between batches so registrations and other
+// events aren't held up by a large fan-out. Clients that connect during the
+// fan-out, or resume from before it, aren't sent the event; they get the
+// notification with their unread ones instead (see fanOut).
+func (h *SSEHub) BroadcastToAll(event string, notificationType string, notification interface{}) BroadcastResult {
+	// Encoded once and embedded in every user's message
+	raw, err := json.Marshal(notification)
+	if err != nil {
+		log.Printf("Error marshaling SSE broadcast: %v", err)
+		return BroadcastResult{}
+	}
+
+	result := h.fanOut(event, notificationType, raw)
+
+	if h.bridge != nil {
+		data, _ := json.Marshal(models.NotificationMessage{
+			Type:         notificationType,
+			Event:        event,
+			Notification: json.RawMessage(raw),
+		})
+		// No user or ID: each instance fans it out under its own IDs
+		h.bridge.Publish(Event{Instance: h.instance, Event: event, Data: data})
+	}
+	return result
+}
+
+// receiveBroadcast fans out a broadcast to all from another instance
+func (h *SSEHub) receiveBroadcast(remote Event) {
+	var message struct {
+		Type         string          `json:"type"`
+		Notification json.RawMessage `json:"notification"`
+	}
+	if err := json.Unmarshal(remote.Data, &message); err != nil {
+		log.Printf("Ignoring malformed SSE broadcast from instance %s: %v", remote.Instance, err)
+		return
+	}
+	result := h.fanOut(remote.Event, message.Type, message.Notification)
+	if h.remoteBroadcast != nil {
+		h.remoteBroadcast(remote.Event, message.Type, result)
+	}
+}
+
+// fanOut delivers an event to every user connected to this instance, with
+// the shards working in parallel
+func (h *SSEHub) fanOut(event string, notificationType string, notification json.RawMessage) BroadcastResult {
+	// Only connected users get the event in their replay log, so a resume
+	// from before it can't be complete; those clients are sent their unread
+	// notifications instead. Events of the fan-out get IDs above marker.
+	marker := h.nextEventID()
+	h.raisePrunedBefore(marker + 1)
+
+	results := make([]BroadcastResult, len(h.shards))
+	var wg sync.WaitGroup
+	for i, s := range h.shards {
+		wg.Add(1)
+		go func(i int, s *shard) {
+			defer wg.Done()
+			results[i] = s.fanOut(event, notificationType, notification)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var result BroadcastResult
+	for _, r := range results {
+		result.UserIDs = append(result.UserIDs, r.UserIDs...)
+		result.Clients += r.Clients
+		result.Dropped += r.Dropped
+	}
+
+	log.Printf("Broadcast %s to %d clients of %d users (%d dropped)", event, result.Clients, len(result.UserIDs), result.Dropped)
+	return result
+}
+
+// fanOut delivers an event to every user connected to the shard
+func (s *shard) fanOut(event string, notificationType string, notification json.RawMessage) BroadcastResult {
+	s.mutex.RLock()
+	userIDs := make([]string, 0, len(s.clients))
+	for userID := range s.clients {
+		userIDs = append(userIDs, userID)
+	}
+	s.mutex.RUnlock()
+
+	result := BroadcastResult{UserIDs: make([]string, 0, len(userIDs))}
+	data := make([][]byte, broadcastBatchSize)
+	for start := 0; start < len(userIDs); start += broadcastBatchSize {
+		batch := userIDs[start:min(start+broadcastBatchSize, len(userIDs))]
+
+		// Encode outside the lock
+		for i, userID := range batch {
+			data[i], _ = json.Marshal(models.NotificationMessage{
+				UserID:       userID,
+				Type:         notificationType,
+				Event:        event,
+				Notification: notification,
+			})
+		}
+
+		s.mutex.Lock()
+		for i, userID := range batch {
+			if len(s.clients[userID]) == 0 {
+				// Disconnected since the snapshot
+				continue
+			}
+			_, sent, dropped := s.deliverLocked(userID, loggedEvent{instance: s.hub.instance, event: event, data: data[i]})
+			result.UserIDs = append(result.UserIDs, userID)
+			result.Clients += sent
+			result.Dropped += dropped
+		}
+		s.mutex.Unlock()
+	}
+	return result
+}
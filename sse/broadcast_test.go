@@ -0,0 +1,68 @@
+package sse
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// nextFrame waits up to a second for the client's next frame
+func nextFrame(t *testing.T, client *SSEClient) []byte {
+	t.Helper()
+
+	select {
+	case frame := <-client.Channel:
+		return frame
+	case <-time.After(time.Second):
+		t.Fatalf("no frame for client %s", client.ID)
+		return nil
+	}
+}
+
+// frameID returns the id line of a frame, or "" if it has none
+func frameID(frame []byte) string {
+	if !bytes.HasPrefix(frame, []byte("id: ")) {
+		return ""
+	}
+	line, _, _ := bytes.Cut(frame[len("id: "):], []byte("\n"))
+	return string(line)
+}
+
+func TestResumeAcrossBroadcastToAll(t *testing.T) {
+	tests := []struct {
+		name         string
+		connected    bool // whether the user was connected for the broadcast
+		wantComplete bool
+	}{
+		{"connected", true, true},
+		{"disconnected", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewSSEHubWithConfig(DefaultConfig())
+			go hub.Run()
+			defer hub.Close()
+
+			client := hub.NewClient("u1", FormatEvents)
+			hub.RegisterClient(client)
+			hub.BroadcastToUser("u1", "new_notification", "user", map[string]string{"id": "n1"})
+			lastEventID := frameID(nextFrame(t, client))
+
+			if !tt.connected {
+				hub.UnregisterClient(client)
+			}
+			hub.BroadcastToAll("new_notification", "system", map[string]string{"id": "s1"})
+			if tt.connected {
+				lastEventID = frameID(nextFrame(t, client))
+				hub.UnregisterClient(client)
+			}
+
+			frames, complete := hub.ResumeClient(hub.NewClient("u1", FormatEvents), lastEventID)
+			if complete != tt.wantComplete || len(frames) != 0 {
+				t.Errorf("resume from %s: got %d frames, complete %v; want none, complete %v",
+					lastEventID, len(frames), complete, tt.wantComplete)
+			}
+		})
+	}
+}
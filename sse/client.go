@@ -0,0 +1,152 @@
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+// SSEClient represents a connected SSE client
+type SSEClient struct {
+	UserID  string
+	Channel chan []byte
+	Done    chan bool
+	ID      string
+	Format  Format
+
+	// mutex guards the fields below and sends on Channel
+	mutex sync.Mutex
+	// dropped counts frames that didn't fit in Channel
+	dropped int64
+	// stalledSince is when Channel filled up, zero while it has room
+	stalledSince time.Time
+	closed       bool
+}
+
+// NewClient creates a client for one of userID's streams with the hub's
+// buffer size; register it with RegisterClient
+func (h *SSEHub) NewClient(userID string, format Format) *SSEClient {
+	return &SSEClient{
+		UserID: userID,
+		// One slot more than ClientBuffer is kept free for resync_required
+		Channel: make(chan []byte, h.config.ClientBuffer+1),
+		Done:    make(chan bool),
+		ID:      fmt.Sprintf("%s_%d", userID, time.Now().UnixNano()),
+		Format:  format,
+	}
+}
+
+// Send queues a frame without blocking. If the client has fallen behind and
+// its buffer is full, the frame is dropped and, the first time, the client
+// is sent resync_required in the slot kept free for it, telling it to
+// refetch its notifications. It returns false if the frame was dropped.
+func (c *SSEClient) Send(frame []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return false
+	}
+	if len(c.Channel) < cap(c.Channel)-1 {
+		c.Channel <- frame
+		c.stalledSince = time.Time{}
+		return true
+	}
+
+	c.dropped++
+	if c.stalledSince.IsZero() {
+		c.stalledSince = time.Now()
+		c.queueResync("slow_consumer")
+	}
+	return false
+}
+
+// Resync tells the client to refetch its notifications, e.g. because events
+// may have been missed. It is skipped if the client already has one queued.
+func (c *SSEClient) Resync(reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed || len(c.Channel) == cap(c.Channel) {
+		return
+	}
+	if len(c.Channel) == cap(c.Channel)-1 && c.stalledSince.IsZero() {
+		// Taking the reserved slot marks the client stalled, as Send does,
+		// so Send doesn't try to queue another resync in it
+		c.stalledSince = time.Now()
+	}
+	c.queueResync(reason)
+}
+
+// queueResync queues a resync_required event, never blocking the caller, who
+// may hold a shard's lock. The caller holds the mutex.
+func (c *SSEClient) queueResync(reason string) {
+	select {
+	case c.Channel <- c.resyncFrame(reason):
+	default:
+	}
+}
+
+// resyncFrame encodes a resync_required event. The caller holds the mutex.
+func (c *SSEClient) resyncFrame(reason string) []byte {
+	data, _ := json.Marshal(models.NotificationMessage{
+		UserID: c.UserID,
+		Type:   "system",
+		Event:  "resync_required",
+		Notification: map[string]interface{}{
+			"reason":  reason,
+			"dropped": c.dropped,
+			"time":    time.Now().Format(time.RFC3339),
+		},
+	})
+	return c.Frame(0, "resync_required", data)
+}
+
+// Dropped returns how many frames were dropped because the client fell behind
+func (c *SSEClient) Dropped() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.dropped
+}
+
+// stalled reports whether the client's buffer has been full for longer than
+// timeout
+func (c *SSEClient) stalled(now time.Time, timeout time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.stalledSince.IsZero() {
+		return false
+	}
+	if len(c.Channel) < cap(c.Channel)-1 {
+		// Caught up since, without anything new to send
+		c.stalledSince = time.Time{}
+		return false
+	}
+	return now.Sub(c.stalledSince) > timeout
+}
+
+// close ends the client's stream. Sends after close are dropped.
+func (c *SSEClient) close() {
+	c.closeWith(nil)
+}
+
+// closeWith queues a last frame, if there's room for it, and ends the
+// client's stream
+func (c *SSEClient) closeWith(frame []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return
+	}
+	if frame != nil && len(c.Channel) < cap(c.Channel) {
+		c.Channel <- frame
+	}
+	c.closed = true
+	close(c.Done)
+	close(c.Channel)
+}
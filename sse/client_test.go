@@ -0,0 +1,105 @@
+package sse
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// returnsWithin fails the test if fn doesn't return within a second
+func returnsWithin(t *testing.T, what string, fn func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s blocked", what)
+	}
+}
+
+func TestSendAfterResyncOnFullClient(t *testing.T) {
+	config := DefaultConfig()
+	config.ClientBuffer = 2
+	hub := NewSSEHubWithConfig(config)
+	client := hub.NewClient("u1", FormatEvents)
+
+	// Fill the buffer, leaving only the slot reserved for resync_required
+	for i := 0; i < config.ClientBuffer; i++ {
+		if !client.Send([]byte("frame")) {
+			t.Fatalf("frame %d dropped with room in the buffer", i)
+		}
+	}
+
+	returnsWithin(t, "Resync on a full client", func() { client.Resync("events_missed") })
+	returnsWithin(t, "Send after Resync took the reserved slot", func() {
+		if client.Send([]byte("frame")) {
+			t.Error("Send queued a frame on a full client")
+		}
+	})
+	returnsWithin(t, "a second Resync", func() { client.Resync("events_missed") })
+
+	if got := len(client.Channel); got != cap(client.Channel) {
+		t.Errorf("channel holds %d frames, want %d", got, cap(client.Channel))
+	}
+	if !client.stalled(time.Now().Add(time.Minute), time.Second) {
+		t.Error("client that took the reserved slot isn't considered stalled")
+	}
+}
+
+func TestSlowConsumerIsResyncedThenDisconnected(t *testing.T) {
+	for _, buffer := range []int{1, 4} {
+		t.Run(fmt.Sprintf("buffer=%d", buffer), func(t *testing.T) {
+			config := DefaultConfig()
+			config.ClientBuffer = buffer
+			hub := NewSSEHubWithConfig(config)
+			go hub.Run()
+			defer hub.Close()
+			client := hub.NewClient("u1", FormatEvents)
+			hub.RegisterClient(client)
+
+			// Nothing reads the client's channel
+			const extra = 3
+			for i := 0; i < buffer+extra; i++ {
+				hub.BroadcastToUser("u1", "new_notification", "user", map[string]int{"n": i})
+			}
+			deadline := time.Now().Add(time.Second)
+			for client.Dropped() < extra && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if dropped := client.Dropped(); dropped != extra {
+				t.Fatalf("dropped %d frames, want %d", dropped, extra)
+			}
+
+			// The buffer holds the first events, then one resync_required
+			for i := 0; i < buffer; i++ {
+				if frame := <-client.Channel; !strings.Contains(string(frame), "event: new_notification") {
+					t.Errorf("frame %d = %q, want new_notification", i, frame)
+				}
+			}
+			if frame := <-client.Channel; !strings.Contains(string(frame), `"reason":"slow_consumer"`) {
+				t.Errorf("got %q, want resync_required for slow_consumer", frame)
+			}
+
+			// Falling behind again and staying behind past StallTimeout gets
+			// it disconnected
+			for i := 0; i <= buffer; i++ {
+				client.Send([]byte("frame"))
+			}
+			hub.shardFor("u1").disconnectStalled(time.Now().Add(config.StallTimeout + time.Second))
+			select {
+			case <-client.Done:
+			default:
+				t.Error("stalled client wasn't disconnected")
+			}
+			if hub.HasClients("u1") {
+				t.Error("stalled client is still registered")
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package sse
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Config tunes the hub
+type Config struct {
+	// ReplaySize is how many recent events are kept per user for Last-Event-ID replay
+	ReplaySize int
+	// ReplayTTL is how long an event stays replayable
+	ReplayTTL time.Duration
+	// Retry is the reconnection delay sent to clients; 0 leaves it to the browser
+	Retry time.Duration
+	// Format is the wire format for clients that don't ask for one
+	Format Format
+	// ClientBuffer is how many events a client can fall behind by before
+	// events are dropped and it is told to resync
+	ClientBuffer int
+	// StallTimeout is how long a client can stay that far behind before it
+	// is disconnected
+	StallTimeout time.Duration
+	// Shards is how many independently locked shards clients are spread
+	// across by user ID
+	Shards int
+	// Bridge relays events between instances over Postgres LISTEN/NOTIFY
+	// when the Postgres store is in use
+	Bridge bool
+	// BridgeChannel is the Postgres channel the bridge uses
+	BridgeChannel string
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		ReplaySize: 100,
+		ReplayTTL:  10 * time.Minute,
+		Retry:      3 * time.Second,
+		Format:     FormatEvents,
+
+		ClientBuffer: 64,
+		StallTimeout: 30 * time.Second,
+		Shards:       runtime.GOMAXPROCS(0),
+
+		Bridge:        true,
+		BridgeChannel: "noti_sse_events",
+	}
+}
+
+// ConfigFromEnv reads the hub settings from the environment:
+//
+//	SSE_REPLAY_SIZE     events kept per user for replay (default 100, 0 disables replay)
+//	SSE_REPLAY_TTL      how long events stay replayable, as a Go duration (default 10m)
+//	SSE_RETRY           reconnection delay sent to clients, as a Go duration (default 3s, 0 sends none)
+//	SSE_FORMAT          "events" for named events or "legacy" for unnamed data messages (default events)
+//	SSE_CLIENT_BUFFER   events a client can fall behind by before it must resync (default 64)
+//	SSE_STALL_TIMEOUT   how long a client can stay behind before it is disconnected (default 30s)
+//	SSE_SHARDS          how many shards clients are spread across (default GOMAXPROCS)
+//	SSE_BRIDGE          "false" to stop relaying events between instances (default true)
+//	SSE_BRIDGE_CHANNEL  Postgres channel for relayed events (default noti_sse_events)
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	if v := os.Getenv("SSE_REPLAY_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 0 {
+			return config, fmt.Errorf("invalid SSE_REPLAY_SIZE %q: must be a non-negative number", v)
+		}
+		config.ReplaySize = size
+	}
+	if v := os.Getenv("SSE_REPLAY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return config, fmt.Errorf("invalid SSE_REPLAY_TTL %q: must be a positive duration", v)
+		}
+		config.ReplayTTL = ttl
+	}
+	if v := os.Getenv("SSE_RETRY"); v != "" {
+		retry, err := time.ParseDuration(v)
+		if err != nil || retry < 0 {
+			return config, fmt.Errorf("invalid SSE_RETRY %q: must be a non-negative duration", v)
+		}
+		config.Retry = retry
+	}
+	if v := os.Getenv("SSE_FORMAT"); v != "" {
+		format, err := ParseFormat(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid SSE_FORMAT: %w", err)
+		}
+		config.Format = format
+	}
+	if v := os.Getenv("SSE_CLIENT_BUFFER"); v != "" {
+		buffer, err := strconv.Atoi(v)
+		if err != nil || buffer < 1 {
+			return config, fmt.Errorf("invalid SSE_CLIENT_BUFFER %q: must be a positive number", v)
+		}
+		config.ClientBuffer = buffer
+	}
+	if v := os.Getenv("SSE_STALL_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return config, fmt.Errorf("invalid SSE_STALL_TIMEOUT %q: must be a positive duration", v)
+		}
+		config.StallTimeout = timeout
+	}
+	if v := os.Getenv("SSE_SHARDS"); v != "" {
+		shards, err := strconv.Atoi(v)
+		if err != nil || shards < 1 {
+			return config, fmt.Errorf("invalid SSE_SHARDS %q: must be a positive number", v)
+		}
+		config.Shards = shards
+	}
+	if v := os.Getenv("SSE_BRIDGE"); v != "" {
+		bridge, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid SSE_BRIDGE %q: must be true or false", v)
+		}
+		config.Bridge = bridge
+	}
+	if v := os.Getenv("SSE_BRIDGE_CHANNEL"); v != "" {
+		config.BridgeChannel = v
+	}
+
+	return config, nil
+}
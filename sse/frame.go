@@ -0,0 +1,72 @@
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Format is how events are written on the wire
+type Format int
+
+const (
+	// FormatEvents names every event with an "event:" line so clients can use
+	// addEventListener, and sends heartbeats as comment lines
+	FormatEvents Format = iota
+	// FormatLegacy sends every event as an unnamed "data:" message with the
+	// name only inside the JSON, and heartbeats as data messages. Clients
+	// written against onmessage keep working with it.
+	FormatLegacy
+)
+
+// ParseFormat reads a format name: "events" or "legacy"
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "events":
+		return FormatEvents, nil
+	case "legacy":
+		return FormatLegacy, nil
+	}
+	return FormatEvents, fmt.Errorf("unknown SSE format %q: must be events or legacy", s)
+}
+
+// String returns the format name
+func (f Format) String() string {
+	if f == FormatLegacy {
+		return "legacy"
+	}
+	return "events"
+}
+
+// Frame encodes an event in the client's format. An id of 0 sends no id line.
+func (c *SSEClient) Frame(id int64, event string, data []byte) []byte {
+	var buf bytes.Buffer
+	if id != 0 {
+		buf.WriteString("id: ")
+		buf.WriteString(strconv.FormatInt(id, 10))
+		buf.WriteByte('\n')
+	}
+	if c.Format != FormatLegacy && event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(event)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	return buf.Bytes()
+}
+
+// Heartbeat encodes a keep-alive in the client's format
+func (c *SSEClient) Heartbeat(now time.Time) []byte {
+	if c.Format == FormatLegacy {
+		return []byte(fmt.Sprintf("data: {\"type\": \"heartbeat\", \"timestamp\": \"%s\"}\n\n", now.Format(time.RFC3339)))
+	}
+	return []byte(fmt.Sprintf(": heartbeat %s\n\n", now.Format(time.RFC3339)))
+}
+
+// Retry encodes a reconnection delay hint for EventSource
+func Retry(delay time.Duration) []byte {
+	return []byte(fmt.Sprintf("retry: %d\n\n", delay.Milliseconds()))
+}
@@ -1,109 +1,260 @@
 package sse
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ktappdev/noti-service/models"
 )
 
-// SSEClient represents a connected SSE client
-type SSEClient struct {
-	UserID  string
-	Channel chan []byte
-	Done    chan bool
-	ID      string
+// SSEHub manages SSE connections and broadcasts. Clients are sharded by user
+// ID, each shard with its own lock and worker, so registrations and events
+// for different users don't queue up behind each other.
+type SSEHub struct {
+	shards []*shard
+	config Config
+
+	// lastEventID is the last event ID handed out
+	lastEventID int64
+	// startEventID is the first event ID of this process; older IDs can't be replayed
+	startEventID int64
+	// prunedBefore is the oldest event ID the replay logs are sure to cover;
+	// older ones may have been pruned by ReplayTTL or missed by the bridge
+	prunedBefore int64
+
+	// instance identifies this hub's events to the other instances
+	instance string
+	// bridge relays events to and from other instances, if there are any
+	bridge Bridge
+	// remoteBroadcast is called after a broadcast to all from another
+	// instance has been fanned out here; see OnRemoteBroadcast
+	remoteBroadcast func(event string, notificationType string, result BroadcastResult)
+
+	// shuttingDown is set by Shutdown, after which clients are turned away
+	shuttingDown int32
+	// closeMutex guards closed against sends on the shard queues
+	closeMutex sync.RWMutex
+	closed     bool
+	// workers tracks the shards' workers, which Close waits for
+	workers sync.WaitGroup
+	// stop ends Run
+	stop chan struct{}
 }
 
-// SSEHub manages SSE connections and broadcasts
-type SSEHub struct {
-	clients    map[string][]*SSEClient // userID -> []*SSEClient
-	register   chan *SSEClient
-	unregister chan *SSEClient
-	broadcast  chan models.NotificationMessage
-	mutex      sync.RWMutex
+// replayLog is a user's recent events, oldest first
+type replayLog struct {
+	events []loggedEvent
+	// evicted is the ID of the newest event missing from the log, because it
+	// was dropped to keep the log within ReplaySize or never arrived whole
+	evicted int64
 }
 
-// NewSSEHub creates a new SSE hub
+// stallCheckInterval is how often clients are checked against StallTimeout
+const stallCheckInterval = 5 * time.Second
+
+// loggedEvent is one event as broadcast, encoded per client when sent
+type loggedEvent struct {
+	id       int64
+	instance string
+	event    string
+	data     []byte
+}
+
+// NewSSEHub creates a new SSE hub with the default config
 func NewSSEHub() *SSEHub {
-	return &SSEHub{
-		clients:    make(map[string][]*SSEClient),
-		register:   make(chan *SSEClient),
-		unregister: make(chan *SSEClient),
-		broadcast:  make(chan models.NotificationMessage),
+	return NewSSEHubWithConfig(DefaultConfig())
+}
+
+// NewSSEHubWithConfig creates a new SSE hub
+func NewSSEHubWithConfig(config Config) *SSEHub {
+	if config.ClientBuffer <= 0 {
+		config.ClientBuffer = DefaultConfig().ClientBuffer
+	}
+	if config.StallTimeout <= 0 {
+		config.StallTimeout = DefaultConfig().StallTimeout
+	}
+	if config.Shards <= 0 {
+		config.Shards = DefaultConfig().Shards
+	}
+	start := time.Now().UnixMicro()
+	h := &SSEHub{
+		config:       config,
+		lastEventID:  start - 1,
+		startEventID: start,
+		instance:     newInstanceID(),
+		stop:         make(chan struct{}),
+	}
+	h.shards = make([]*shard, config.Shards)
+	for i := range h.shards {
+		h.shards[i] = newShard(h)
+	}
+	return h
+}
+
+// newInstanceID returns a random ID for this process
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// SetBridge relays the hub's events to other instances through bridge and
+// lets it deliver theirs with Receive. Call it before Run.
+func (h *SSEHub) SetBridge(bridge Bridge) {
+	h.bridge = bridge
+}
+
+// Bridged reports whether the hub relays events to other instances, so a
+// user may have streams open elsewhere even when HasClients is false
+func (h *SSEHub) Bridged() bool {
+	return h.bridge != nil
+}
+
+// OnRemoteBroadcast sets fn to be called with who a broadcast to all from
+// another instance reached on this one, e.g. to follow it with their unread
+// counts. Call it before Run.
+func (h *SSEHub) OnRemoteBroadcast(fn func(event string, notificationType string, result BroadcastResult)) {
+	h.remoteBroadcast = fn
+}
+
+// Config returns the hub's settings
+func (h *SSEHub) Config() Config {
+	return h.config
+}
+
+// shardFor returns the shard that holds the user's clients
+func (h *SSEHub) shardFor(userID string) *shard {
+	if len(h.shards) == 1 {
+		return h.shards[0]
 	}
+	hash := fnv.New32a()
+	hash.Write([]byte(userID))
+	return h.shards[hash.Sum32()%uint32(len(h.shards))]
 }
 
-// Run starts the SSE hub event loop
+// nextEventID returns a new event ID. IDs are microsecond timestamps, bumped
+// when needed so they always increase, which keeps them increasing across
+// restarts too.
+func (h *SSEHub) nextEventID() int64 {
+	for {
+		last := atomic.LoadInt64(&h.lastEventID)
+		id := time.Now().UnixMicro()
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&h.lastEventID, last, id) {
+			return id
+		}
+	}
+}
+
+// observeEventID makes sure IDs handed out later are above id, so events
+// from other instances and this one stay in order
+func (h *SSEHub) observeEventID(id int64) {
+	for {
+		last := atomic.LoadInt64(&h.lastEventID)
+		if id <= last || atomic.CompareAndSwapInt64(&h.lastEventID, last, id) {
+			return
+		}
+	}
+}
+
+// raisePrunedBefore moves prunedBefore up to id
+func (h *SSEHub) raisePrunedBefore(id int64) {
+	for {
+		last := atomic.LoadInt64(&h.prunedBefore)
+		if id <= last || atomic.CompareAndSwapInt64(&h.prunedBefore, last, id) {
+			return
+		}
+	}
+}
+
+// Run starts the shards' workers and the hub's housekeeping, until Close
 func (h *SSEHub) Run() {
+	for _, s := range h.shards {
+		h.workers.Add(1)
+		go func(s *shard) {
+			defer h.workers.Done()
+			s.run()
+		}(s)
+	}
+
+	prune := time.NewTicker(time.Minute)
+	defer prune.Stop()
+	stalls := time.NewTicker(stallCheckInterval)
+	defer stalls.Stop()
+
 	for {
 		select {
-		case client := <-h.register:
-			h.mutex.Lock()
-			if _, ok := h.clients[client.UserID]; !ok {
-				h.clients[client.UserID] = make([]*SSEClient, 0)
-			}
-			h.clients[client.UserID] = append(h.clients[client.UserID], client)
-			h.mutex.Unlock()
-
-		case client := <-h.unregister:
-			h.mutex.Lock()
-			if clients, ok := h.clients[client.UserID]; ok {
-				for i, c := range clients {
-					if c.ID == client.ID {
-						// Remove client from slice
-						h.clients[client.UserID] = append(clients[:i], clients[i+1:]...)
-						// Safely close channels
-						select {
-						case c.Done <- true:
-						default:
-						}
-						// Close channel in a goroutine to prevent blocking
-						go func(ch chan []byte) {
-							defer func() {
-								if r := recover(); r != nil {
-									log.Printf("Error closing SSE channel: %v", r)
-								}
-							}()
-							close(ch)
-						}(c.Channel)
-						break
-					}
-				}
-				// Remove user entry if no clients left
-				if len(h.clients[client.UserID]) == 0 {
-					delete(h.clients, client.UserID)
-				}
+		case <-prune.C:
+			// Event IDs are timestamps, so everything before the cutoff ID goes
+			cutoff := time.Now().Add(-h.config.ReplayTTL).UnixMicro()
+			h.raisePrunedBefore(cutoff)
+			for _, s := range h.shards {
+				s.pruneReplay(cutoff)
 			}
-			h.mutex.Unlock()
-
-		case message := <-h.broadcast:
-			h.mutex.RLock()
-			if clients, ok := h.clients[message.UserID]; ok {
-				messageBytes, err := json.Marshal(message)
-				if err != nil {
-					log.Printf("Error marshaling SSE message: %v", err)
-					h.mutex.RUnlock()
-					continue
-				}
-
-				sseData := fmt.Sprintf("data: %s\n\n", messageBytes)
-				for _, client := range clients {
-					select {
-					case client.Channel <- []byte(sseData):
-					default:
-						// Client channel is full, skip
-					}
-				}
+
+		case now := <-stalls.C:
+			for _, s := range h.shards {
+				s.disconnectStalled(now)
 			}
-			h.mutex.RUnlock()
+
+		case <-h.stop:
+			return
 		}
 	}
 }
 
-// BroadcastToUser sends a notification to all connected clients for a specific user
+// MarkGap records that events up to now may have been missed, e.g. while the
+// bridge was disconnected. Connected clients are told to resync, and clients
+// resuming from before now are resynced rather than given a replay with
+// holes in it.
+func (h *SSEHub) MarkGap() {
+	h.raisePrunedBefore(h.nextEventID())
+	for _, s := range h.shards {
+		s.resyncAll("events_missed")
+	}
+}
+
+// Receive delivers an event published by another instance to this
+// instance's clients. Events this hub published itself are ignored.
+func (h *SSEHub) Receive(event Event) {
+	if event.Instance == h.instance {
+		return
+	}
+
+	h.closeMutex.RLock()
+	defer h.closeMutex.RUnlock()
+	if h.closed {
+		return
+	}
+	if event.UserID == "" {
+		if event.Oversize {
+			// Nothing to fan out, so everyone may have missed it
+			h.observeEventID(event.ID)
+			h.MarkGap()
+			return
+		}
+		// A broadcast to all; fanned out without holding up the bridge
+		go h.receiveBroadcast(event)
+		return
+	}
+	h.shardFor(event.UserID).queue <- delivery{remote: &event}
+}
+
+// BroadcastToUser sends a notification to all connected clients for a specific user.
+// It waits for the user's shard to accept the message rather than dropping it
+// when the shard is busy, so back-to-back events (e.g. new_notification then
+// unread_count) all arrive, in order.
 func (h *SSEHub) BroadcastToUser(userID string, event string, notificationType string, notification interface{}) {
 	message := models.NotificationMessage{
 		UserID:       userID,
@@ -112,38 +263,60 @@ func (h *SSEHub) BroadcastToUser(userID string, event string, notificationType s
 		Event:        event,
 	}
 
-	select {
-	case h.broadcast <- message:
-	default:
+	h.closeMutex.RLock()
+	defer h.closeMutex.RUnlock()
+	if h.closed {
+		log.Printf("SSE hub is closed, dropping %s for user %s", event, userID)
+		return
 	}
+	h.shardFor(userID).queue <- delivery{message: &message}
 }
 
-// BroadcastToAll sends a notification to all connected clients (for system notifications)
-func (h *SSEHub) BroadcastToAll(event string, notificationType string, notification interface{}) {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
+// HasClients reports whether the user has at least one open stream
+func (h *SSEHub) HasClients(userID string) bool {
+	s := h.shardFor(userID)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.clients[userID]) > 0
+}
 
-	for userID := range h.clients {
-		message := models.NotificationMessage{
-			UserID:       userID,
-			Type:         notificationType,
-			Notification: notification,
-			Event:        event,
-		}
+// RegisterClient registers a new SSE client. Once the hub is shutting down
+// the client's stream is ended straight away.
+func (h *SSEHub) RegisterClient(client *SSEClient) {
+	s := h.shardFor(client.UserID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addClient(client)
+}
 
-		select {
-		case h.broadcast <- message:
-		default:
-		}
+// ResumeClient registers a client resuming with Last-Event-ID and returns its
+// events after lastEventID, oldest first and encoded in the client's format.
+// Both happen under the shard's lock, so every event is either in the replay
+// or sent to the client's Channel afterwards, never both or neither.
+//
+// complete is false when some of the missed events are no longer known,
+// because they were pruned, happened before a restart, or lastEventID isn't
+// one of ours; the caller should then resync the client another way.
+func (h *SSEHub) ResumeClient(client *SSEClient, lastEventID string) (frames [][]byte, complete bool) {
+	s := h.shardFor(client.UserID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.addClient(client) {
+		return nil, false
 	}
-}
 
-// RegisterClient registers a new SSE client
-func (h *SSEHub) RegisterClient(client *SSEClient) {
-	h.register <- client
+	after, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return s.replaySince(client, after)
 }
 
 // UnregisterClient unregisters an SSE client
 func (h *SSEHub) UnregisterClient(client *SSEClient) {
-	h.unregister <- client
-}
\ No newline at end of file
+	s := h.shardFor(client.UserID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.removeClient(client)
+}
@@ -0,0 +1,389 @@
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+// benchmarkUsers is how many users have a stream open in the benchmarks
+const benchmarkUsers = 10000
+
+// benchmarkHubs are the hubs compared: the event loop the hub used before it
+// was sharded, and the sharded hub with one and with many shards
+var benchmarkHubs = []struct {
+	name   string
+	shards int // 0 for the event loop
+}{
+	{"loop", 0},
+	{"shards=1", 1},
+	{"shards=16", 16},
+}
+
+// benchmarkedHub is the API the benchmarks drive
+type benchmarkedHub interface {
+	NewClient(userID string, format Format) *SSEClient
+	RegisterClient(client *SSEClient)
+	UnregisterClient(client *SSEClient)
+	BroadcastToUser(userID string, event string, notificationType string, notification interface{})
+	Close()
+	// dropped counts the frames dropped by the connected clients
+	dropped() int64
+}
+
+// loopHub is the hub as it was before sharding: one goroutine takes
+// registrations, unregistrations and events off unbuffered channels and
+// applies them under a single lock. It keeps its clients and replay logs in
+// a shard, so each event costs the same work as in SSEHub.
+type loopHub struct {
+	*SSEHub
+	register   chan *SSEClient
+	unregister chan *SSEClient
+	broadcast  chan models.NotificationMessage
+	stop       chan struct{}
+}
+
+func newLoopHub(config Config) *loopHub {
+	config.Shards = 1
+	l := &loopHub{
+		SSEHub:     NewSSEHubWithConfig(config),
+		register:   make(chan *SSEClient),
+		unregister: make(chan *SSEClient),
+		broadcast:  make(chan models.NotificationMessage),
+		stop:       make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *loopHub) run() {
+	s := l.shards[0]
+	for {
+		select {
+		case client := <-l.register:
+			s.mutex.Lock()
+			s.addClient(client)
+			s.mutex.Unlock()
+
+		case client := <-l.unregister:
+			s.mutex.Lock()
+			s.removeClient(client)
+			s.mutex.Unlock()
+
+		case message := <-l.broadcast:
+			data, err := json.Marshal(message)
+			if err != nil {
+				log.Printf("Error marshaling SSE message: %v", err)
+				continue
+			}
+			s.deliver(message.UserID, loggedEvent{instance: l.instance, event: message.Event, data: data})
+
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *loopHub) RegisterClient(client *SSEClient) {
+	l.register <- client
+}
+
+func (l *loopHub) UnregisterClient(client *SSEClient) {
+	l.unregister <- client
+}
+
+func (l *loopHub) BroadcastToUser(userID string, event string, notificationType string, notification interface{}) {
+	l.broadcast <- models.NotificationMessage{UserID: userID, Type: notificationType, Notification: notification, Event: event}
+}
+
+func (l *loopHub) Close() {
+	close(l.stop)
+}
+
+func (h *SSEHub) dropped() int64 {
+	var dropped int64
+	for _, s := range h.shards {
+		s.mutex.RLock()
+		for _, clients := range s.clients {
+			for _, client := range clients {
+				dropped += client.Dropped()
+			}
+		}
+		s.mutex.RUnlock()
+	}
+	return dropped
+}
+
+// benchmarkHub starts a hub with one draining client per user. It returns
+// the hub, a count of the frames the clients have received, and a function
+// that disconnects the clients and closes the hub.
+func benchmarkHub(b *testing.B, shards int) (benchmarkedHub, *int64, func()) {
+	b.Helper()
+
+	config := DefaultConfig()
+	config.ClientBuffer = 1024
+	var hub benchmarkedHub
+	if shards == 0 {
+		hub = newLoopHub(config)
+	} else {
+		config.Shards = shards
+		sharded := NewSSEHubWithConfig(config)
+		go sharded.Run()
+		hub = sharded
+	}
+
+	var received int64
+	var wg sync.WaitGroup
+	clients := make([]*SSEClient, benchmarkUsers)
+	for i := range clients {
+		clients[i] = hub.NewClient(fmt.Sprintf("user_%d", i), FormatEvents)
+		hub.RegisterClient(clients[i])
+		wg.Add(1)
+		go func(client *SSEClient) {
+			defer wg.Done()
+			for range client.Channel {
+				atomic.AddInt64(&received, 1)
+			}
+		}(clients[i])
+	}
+
+	stop := func() {
+		for _, client := range clients {
+			hub.UnregisterClient(client)
+		}
+		wg.Wait()
+		// Keep the shutdown log out of the results
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+		hub.Close()
+	}
+	return hub, &received, stop
+}
+
+// waitForDelivery waits until the clients have received or dropped n frames
+func waitForDelivery(b *testing.B, hub benchmarkedHub, received *int64, n int64) {
+	b.Helper()
+
+	deadline := time.Now().Add(time.Minute)
+	for time.Now().Before(deadline) {
+		if atomic.LoadInt64(received)+hub.dropped() >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b.Fatalf("only %d of %d frames delivered", atomic.LoadInt64(received), n)
+}
+
+// BenchmarkBroadcastToUser measures events per second from many concurrent
+// senders to random users, through to the clients' channels
+func BenchmarkBroadcastToUser(b *testing.B) {
+	for _, bh := range benchmarkHubs {
+		b.Run(bh.name, func(b *testing.B) {
+			hub, received, stop := benchmarkHub(b, bh.shards)
+			defer stop()
+			notification := map[string]string{"message": "benchmark"}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(time.Now().UnixNano()))
+				for pb.Next() {
+					hub.BroadcastToUser(fmt.Sprintf("user_%d", r.Intn(benchmarkUsers)), "new_notification", "user", notification)
+				}
+			})
+			waitForDelivery(b, hub, received, int64(b.N))
+		})
+	}
+}
+
+// BenchmarkRegisterDuringBroadcasts measures connecting and disconnecting
+// clients, as after a deploy, while events are being broadcast
+func BenchmarkRegisterDuringBroadcasts(b *testing.B) {
+	for _, bh := range benchmarkHubs {
+		b.Run(bh.name, func(b *testing.B) {
+			hub, _, stop := benchmarkHub(b, bh.shards)
+			defer stop()
+
+			done := make(chan struct{})
+			var senders sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				senders.Add(1)
+				go func(i int) {
+					defer senders.Done()
+					notification := map[string]string{"message": "benchmark"}
+					for n := i; ; n++ {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						hub.BroadcastToUser(fmt.Sprintf("user_%d", n%benchmarkUsers), "new_notification", "user", notification)
+					}
+				}(i)
+			}
+
+			var next int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := atomic.AddInt64(&next, 1)
+					client := hub.NewClient(fmt.Sprintf("user_%d", n%benchmarkUsers), FormatEvents)
+					hub.RegisterClient(client)
+					hub.UnregisterClient(client)
+				}
+			})
+			b.StopTimer()
+
+			close(done)
+			senders.Wait()
+		})
+	}
+}
+
+func TestShardsDeliverToTheirUsers(t *testing.T) {
+	for _, shards := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			config := DefaultConfig()
+			config.Shards = shards
+			hub := NewSSEHubWithConfig(config)
+			go hub.Run()
+			defer hub.Close()
+			if len(hub.shards) != shards {
+				t.Fatalf("hub has %d shards, want %d", len(hub.shards), shards)
+			}
+
+			used := make(map[*shard]bool)
+			clients := make([]*SSEClient, 64)
+			for i := range clients {
+				clients[i] = hub.NewClient(fmt.Sprintf("user_%d", i), FormatEvents)
+				hub.RegisterClient(clients[i])
+				used[hub.shardFor(clients[i].UserID)] = true
+			}
+			if shards > 1 && len(used) == 1 {
+				t.Errorf("every user hashed to the same one of %d shards", shards)
+			}
+
+			for _, client := range clients {
+				hub.BroadcastToUser(client.UserID, "new_notification", "user", map[string]string{"to": client.UserID})
+			}
+			for _, client := range clients {
+				frame := nextFrame(t, client)
+				if !strings.Contains(string(frame), `"to":"`+client.UserID+`"`) {
+					t.Errorf("%s got %q", client.UserID, frame)
+				}
+				if len(client.Channel) != 0 {
+					t.Errorf("%s got %d more frames than its own", client.UserID, len(client.Channel))
+				}
+			}
+
+			hub.UnregisterClient(clients[0])
+			if hub.HasClients(clients[0].UserID) || !hub.HasClients(clients[1].UserID) {
+				t.Error("HasClients doesn't match the registered clients")
+			}
+		})
+	}
+}
+
+func TestResumeClient(t *testing.T) {
+	tests := []struct {
+		name         string
+		lastEventID  func(ids []string) string
+		wantFrames   []int // indexes into ids
+		wantComplete bool
+	}{
+		{"missed events", func(ids []string) string { return ids[1] }, []int{2, 3}, true},
+		{"up to date", func(ids []string) string { return ids[3] }, nil, true},
+		{"evicted from the log", func(ids []string) string { return ids[0] }, nil, false},
+		{"from before the hub started", func([]string) string { return "1" }, nil, false},
+		{"not an event id", func([]string) string { return "abc" }, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.ReplaySize = 2
+			hub := NewSSEHubWithConfig(config)
+			go hub.Run()
+			defer hub.Close()
+
+			// The client sees four events, the last two of which stay in the
+			// log, then disconnects
+			client := hub.NewClient("u1", FormatEvents)
+			hub.RegisterClient(client)
+			ids := make([]string, 4)
+			for i := range ids {
+				hub.BroadcastToUser("u1", "new_notification", "user", map[string]int{"n": i})
+				ids[i] = frameID(nextFrame(t, client))
+			}
+			hub.UnregisterClient(client)
+
+			frames, complete := hub.ResumeClient(hub.NewClient("u1", FormatEvents), tt.lastEventID(ids))
+			if complete != tt.wantComplete {
+				t.Errorf("complete = %v, want %v", complete, tt.wantComplete)
+			}
+			if len(frames) != len(tt.wantFrames) {
+				t.Fatalf("replayed %d frames, want %d", len(frames), len(tt.wantFrames))
+			}
+			for i, want := range tt.wantFrames {
+				if got := frameID(frames[i]); got != ids[want] {
+					t.Errorf("frame %d has id %s, want %s", i, got, ids[want])
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveDedupesEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event // IDs are offsets from a base ID
+		want   int
+	}{
+		{"same event twice", []Event{{Instance: "a", ID: 1}, {Instance: "a", ID: 1}}, 1},
+		{"same id from two instances", []Event{{Instance: "a", ID: 1}, {Instance: "b", ID: 1}}, 2},
+		{"out of order", []Event{{Instance: "a", ID: 2}, {Instance: "a", ID: 1}, {Instance: "a", ID: 2}}, 2},
+		{"own event", []Event{{Instance: "self", ID: 1}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewSSEHubWithConfig(DefaultConfig())
+			go hub.Run()
+			defer hub.Close()
+			client := hub.NewClient("u1", FormatEvents)
+			hub.RegisterClient(client)
+
+			base := hub.nextEventID() + 1000
+			for _, event := range tt.events {
+				if event.Instance == "self" {
+					event.Instance = hub.instance
+				}
+				event.ID += base
+				event.UserID = "u1"
+				event.Event = "new_notification"
+				event.Data = json.RawMessage(`{}`)
+				hub.Receive(event)
+			}
+
+			// The shard handles events in order, so everything received has
+			// been delivered once this arrives
+			hub.BroadcastToUser("u1", "done", "system", nil)
+			got := 0
+			for !strings.Contains(string(nextFrame(t, client)), "event: done") {
+				got++
+			}
+			if got != tt.want {
+				t.Errorf("delivered %d events, want %d", got, tt.want)
+			}
+		})
+	}
+}
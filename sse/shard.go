@@ -0,0 +1,290 @@
+package sse
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+// shardQueueSize is how many events a shard's worker can fall behind by
+// before BroadcastToUser waits for it
+const shardQueueSize = 256
+
+// shard holds the clients and replay logs of the users that hash to it
+type shard struct {
+	hub     *SSEHub
+	clients map[string][]*SSEClient // userID -> []*SSEClient
+	replay  map[string]*replayLog   // userID -> recent events for Last-Event-ID replay
+	mutex   sync.RWMutex
+	// queue holds events for the shard's worker in the order they were sent
+	queue chan delivery
+}
+
+// delivery is an event waiting for a shard's worker
+type delivery struct {
+	// message is a broadcast from this instance, encoded by the worker
+	message *models.NotificationMessage
+	// remote is an event from another instance
+	remote *Event
+}
+
+func newShard(hub *SSEHub) *shard {
+	return &shard{
+		hub:     hub,
+		clients: make(map[string][]*SSEClient),
+		replay:  make(map[string]*replayLog),
+		queue:   make(chan delivery, shardQueueSize),
+	}
+}
+
+// run delivers the shard's queued events
+func (s *shard) run() {
+	for d := range s.queue {
+		s.handle(d)
+	}
+}
+
+// handle delivers one queued event
+func (s *shard) handle(d delivery) {
+	h := s.hub
+	if d.remote != nil {
+		h.observeEventID(d.remote.ID)
+		if d.remote.Oversize {
+			s.markMissed(d.remote.UserID, d.remote.ID)
+			return
+		}
+		s.deliver(d.remote.UserID, loggedEvent{id: d.remote.ID, instance: d.remote.Instance, event: d.remote.Event, data: d.remote.Data})
+		return
+	}
+
+	messageBytes, err := json.Marshal(d.message)
+	if err != nil {
+		log.Printf("Error marshaling SSE message: %v", err)
+		return
+	}
+
+	// Every event gets an ID and goes into the user's replay log,
+	// whether or not they are connected right now
+	event := s.deliver(d.message.UserID, loggedEvent{instance: h.instance, event: d.message.Event, data: messageBytes})
+
+	if h.bridge != nil {
+		h.bridge.Publish(Event{
+			Instance: h.instance,
+			ID:       event.id,
+			UserID:   d.message.UserID,
+			Event:    event.event,
+			Data:     event.data,
+		})
+	}
+}
+
+// deliver logs an event and sends it to the user's clients on this instance,
+// returning it with its ID
+func (s *shard) deliver(userID string, event loggedEvent) loggedEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	event, _, _ = s.deliverLocked(userID, event)
+	return event
+}
+
+// deliverLocked logs an event and sends it to the user's clients on this
+// instance. An event without an ID gets the next one here, under the lock,
+// so each client gets IDs in order. An event already in the log has been
+// delivered and is skipped. It returns the event and how many clients it
+// was queued for and dropped for. The caller holds the write lock.
+func (s *shard) deliverLocked(userID string, event loggedEvent) (loggedEvent, int, int) {
+	if event.id == 0 {
+		event.id = s.hub.nextEventID()
+	}
+	if !s.logEvent(userID, event) {
+		return event, 0, 0
+	}
+
+	sent, dropped := 0, 0
+	// Encode once per format rather than once per client
+	var frames [2][]byte
+	for _, client := range s.clients[userID] {
+		if frames[client.Format] == nil {
+			frames[client.Format] = client.Frame(event.id, event.event, event.data)
+		}
+		if client.Send(frames[client.Format]) {
+			sent++
+		} else {
+			dropped++
+		}
+	}
+	return event, sent, dropped
+}
+
+// markMissed records that the user missed an event that couldn't be relayed
+// whole: their clients are told to resync, and resumes from before the event
+// are resynced rather than replayed without it
+func (s *shard) markMissed(userID string, id int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.hub.config.ReplaySize > 0 {
+		replay := s.replay[userID]
+		if replay == nil {
+			replay = &replayLog{}
+			s.replay[userID] = replay
+		}
+		if id > replay.evicted {
+			replay.evicted = id
+		}
+	}
+	for _, client := range s.clients[userID] {
+		client.Resync("events_missed")
+	}
+}
+
+// addClient adds a client, or ends its stream if the hub is shutting down,
+// and reports whether it was added. The caller holds the write lock.
+func (s *shard) addClient(client *SSEClient) bool {
+	if s.hub.ShuttingDown() {
+		client.closeWith(s.hub.shutdownFrame(client))
+		return false
+	}
+	s.clients[client.UserID] = append(s.clients[client.UserID], client)
+	return true
+}
+
+// removeClient removes a client and ends its stream. The caller holds the
+// write lock.
+func (s *shard) removeClient(client *SSEClient) {
+	clients := s.clients[client.UserID]
+	for i, c := range clients {
+		if c.ID == client.ID {
+			s.clients[client.UserID] = append(clients[:i], clients[i+1:]...)
+			c.close()
+			if dropped := c.Dropped(); dropped > 0 {
+				log.Printf("SSE client %s dropped %d messages while connected", c.ID, dropped)
+			}
+			break
+		}
+	}
+	// Remove user entry if no clients left
+	if len(s.clients[client.UserID]) == 0 {
+		delete(s.clients, client.UserID)
+	}
+}
+
+// disconnectStalled drops clients whose buffer has stayed full for longer
+// than StallTimeout. Their browser reconnects and resumes with Last-Event-ID.
+func (s *shard) disconnectStalled(now time.Time) {
+	timeout := s.hub.config.StallTimeout
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var stalled []*SSEClient
+	for _, clients := range s.clients {
+		for _, client := range clients {
+			if client.stalled(now, timeout) {
+				stalled = append(stalled, client)
+			}
+		}
+	}
+	for _, client := range stalled {
+		log.Printf("Disconnecting SSE client %s: stalled for over %s", client.ID, timeout)
+		s.removeClient(client)
+	}
+}
+
+// resyncAll tells every client in the shard to resync
+func (s *shard) resyncAll(reason string) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, clients := range s.clients {
+		for _, client := range clients {
+			client.Resync(reason)
+		}
+	}
+}
+
+// logEvent adds an event to the user's replay log in ID order, dropping the
+// oldest beyond ReplaySize. It returns false if the event is already there.
+// The caller holds the write lock.
+func (s *shard) logEvent(userID string, event loggedEvent) bool {
+	size := s.hub.config.ReplaySize
+	if size <= 0 {
+		return true
+	}
+	replay := s.replay[userID]
+	if replay == nil {
+		replay = &replayLog{}
+		s.replay[userID] = replay
+	}
+
+	// Events from other instances can arrive slightly out of order
+	i := len(replay.events)
+	for i > 0 && replay.events[i-1].id > event.id {
+		i--
+	}
+	for j := i - 1; j >= 0 && replay.events[j].id == event.id; j-- {
+		if replay.events[j].instance == event.instance {
+			return false
+		}
+	}
+	replay.events = append(replay.events, loggedEvent{})
+	copy(replay.events[i+1:], replay.events[i:])
+	replay.events[i] = event
+
+	if excess := len(replay.events) - size; excess > 0 {
+		replay.evicted = replay.events[excess-1].id
+		replay.events = append([]loggedEvent(nil), replay.events[excess:]...)
+	}
+	return true
+}
+
+// pruneReplay drops events before the cutoff ID
+func (s *shard) pruneReplay(cutoff int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for userID, replay := range s.replay {
+		keep := 0
+		for keep < len(replay.events) && replay.events[keep].id < cutoff {
+			keep++
+		}
+		if keep == len(replay.events) && replay.evicted < cutoff {
+			delete(s.replay, userID)
+			continue
+		}
+		replay.events = replay.events[keep:]
+	}
+}
+
+// replaySince returns the client's logged events after the given ID; see
+// SSEHub.ResumeClient. The caller holds the lock.
+func (s *shard) replaySince(client *SSEClient, after int64) (frames [][]byte, complete bool) {
+	h := s.hub
+
+	// The newest event the hub may have forgotten for this user
+	forgotten := h.startEventID - 1
+	if pruned := atomic.LoadInt64(&h.prunedBefore); pruned-1 > forgotten {
+		forgotten = pruned - 1
+	}
+	replay := s.replay[client.UserID]
+	if replay != nil && replay.evicted > forgotten {
+		forgotten = replay.evicted
+	}
+	if h.config.ReplaySize <= 0 || after < forgotten {
+		return nil, false
+	}
+
+	if replay != nil {
+		for _, event := range replay.events {
+			if event.id > after {
+				frames = append(frames, client.Frame(event.id, event.event, event.data))
+			}
+		}
+	}
+	return frames, true
+}
@@ -0,0 +1,95 @@
+package sse
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/ktappdev/noti-service/models"
+)
+
+// ShuttingDown reports whether Shutdown has been called; new streams should
+// be turned away
+func (h *SSEHub) ShuttingDown() bool {
+	return atomic.LoadInt32(&h.shuttingDown) == 1
+}
+
+// Shutdown ends every stream with a server_shutdown event telling the client
+// when to reconnect, by which time a load balancer will have sent it to
+// another instance. Clients registering afterwards are ended the same way.
+// Events keep being logged and relayed to other instances until Close.
+func (h *SSEHub) Shutdown() {
+	if !atomic.CompareAndSwapInt32(&h.shuttingDown, 0, 1) {
+		return
+	}
+
+	ended := 0
+	for _, s := range h.shards {
+		ended += s.shutdown()
+	}
+	log.Printf("Ended %d SSE streams for shutdown", ended)
+}
+
+// Close delivers the events already queued, which still reach the replay logs
+// and the bridge, then stops the hub's workers and Run. Streams still open are
+// ended as by Shutdown. Events sent after Close are dropped.
+func (h *SSEHub) Close() {
+	h.Shutdown()
+
+	h.closeMutex.Lock()
+	if h.closed {
+		h.closeMutex.Unlock()
+		return
+	}
+	h.closed = true
+	h.closeMutex.Unlock()
+
+	for _, s := range h.shards {
+		close(s.queue)
+	}
+	h.workers.Wait()
+	close(h.stop)
+}
+
+// shutdownFrame encodes a server_shutdown event for the client. The reconnect
+// delay is spread between one and two times Retry so clients don't all come
+// back at once.
+func (h *SSEHub) shutdownFrame(client *SSEClient) []byte {
+	retry := h.config.Retry
+	if retry <= 0 {
+		retry = DefaultConfig().Retry
+	}
+	retry += rand.N(retry)
+
+	data, _ := json.Marshal(models.NotificationMessage{
+		UserID: client.UserID,
+		Type:   "system",
+		Event:  "server_shutdown",
+		Notification: map[string]interface{}{
+			"message":            "Server is shutting down, reconnect shortly",
+			"reconnect_after_ms": retry.Milliseconds(),
+			"time":               time.Now().Format(time.RFC3339),
+		},
+	})
+	// The retry line makes EventSource wait that long before reconnecting
+	return append(Retry(retry), client.Frame(0, "server_shutdown", data)...)
+}
+
+// shutdown ends every stream in the shard with server_shutdown and returns
+// how many there were
+func (s *shard) shutdown() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ended := 0
+	for userID, clients := range s.clients {
+		for _, client := range clients {
+			client.closeWith(s.hub.shutdownFrame(client))
+			ended++
+		}
+		delete(s.clients, userID)
+	}
+	return ended
+}
@@ -0,0 +1,82 @@
+package sse
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		retry  time.Duration
+	}{
+		{"events", FormatEvents, time.Second},
+		{"legacy", FormatLegacy, 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Retry = tt.retry
+			hub := NewSSEHubWithConfig(config)
+			go hub.Run()
+			defer hub.Close()
+
+			connected := hub.NewClient("u1", tt.format)
+			hub.RegisterClient(connected)
+			hub.Shutdown()
+			if !hub.ShuttingDown() {
+				t.Fatal("ShuttingDown is false after Shutdown")
+			}
+			// Clients registering afterwards are ended the same way
+			late := hub.NewClient("u2", tt.format)
+			hub.RegisterClient(late)
+
+			for _, client := range []*SSEClient{connected, late} {
+				select {
+				case <-client.Done:
+				default:
+					t.Fatalf("%s's stream wasn't ended", client.UserID)
+				}
+				frame := string(<-client.Channel)
+				if _, ok := <-client.Channel; ok {
+					t.Errorf("%s got frames after server_shutdown", client.UserID)
+				}
+
+				// retry: N, then the event telling the client to wait N ms
+				retryLine, event, _ := strings.Cut(frame, "\n\n")
+				ms, err := strconv.ParseInt(strings.TrimPrefix(retryLine, "retry: "), 10, 64)
+				if err != nil {
+					t.Fatalf("frame doesn't start with a retry line: %q", frame)
+				}
+				if delay := time.Duration(ms) * time.Millisecond; delay < tt.retry || delay >= 2*tt.retry {
+					t.Errorf("retry %s, want between %s and %s", delay, tt.retry, 2*tt.retry)
+				}
+				if named := strings.Contains(event, "event: server_shutdown\n"); named != (tt.format == FormatEvents) {
+					t.Errorf("event line present = %v in %s format: %q", named, tt.format, event)
+				}
+
+				data, _ := strings.CutSuffix(event[strings.Index(event, "data: ")+len("data: "):], "\n\n")
+				var message struct {
+					Event        string `json:"event"`
+					Notification struct {
+						ReconnectAfterMS int64 `json:"reconnect_after_ms"`
+					} `json:"notification"`
+				}
+				if err := json.Unmarshal([]byte(data), &message); err != nil {
+					t.Fatalf("decoding %q: %v", data, err)
+				}
+				if message.Event != "server_shutdown" || message.Notification.ReconnectAfterMS != ms {
+					t.Errorf("got %+v, want server_shutdown with reconnect_after_ms %d", message, ms)
+				}
+			}
+			if hub.HasClients("u1") || hub.HasClients("u2") {
+				t.Error("clients still registered after Shutdown")
+			}
+		})
+	}
+}
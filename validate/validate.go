@@ -0,0 +1,182 @@
+// Package validate checks request payloads against the rules declared in
+// their `validate` struct tags, reporting every failed field at once.
+//
+// A tag is a comma separated list of rules, checked in order:
+//
+//	required      the value must be set: a non-blank string, a non-nil pointer
+//	              or a non-empty slice
+//	max=N         a string may be at most N characters, a slice at most N items
+//	oneof=a b c   a string must be one of the listed values
+//	dive          the rules after it apply to each item of a slice
+//
+// Rules other than required skip empty values. Fields are reported by their
+// JSON names, with items of a slice as name[i].
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ktappdev/noti-service/apperr"
+)
+
+// Struct checks the fields of the struct v points to and returns every
+// failed rule. Rules that can't be parsed panic, as they are programming errors.
+func Struct(v interface{}) []apperr.FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: %T is not a struct", v))
+	}
+
+	var errs []apperr.FieldError
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		errs = append(errs, checkValue(jsonName(field), value.Field(i), strings.Split(tag, ","))...)
+	}
+	return errs
+}
+
+// checkValue applies rules to one value, stopping at its first failure
+func checkValue(name string, value reflect.Value, rules []string) []apperr.FieldError {
+	for i, rule := range rules {
+		if rule == "dive" {
+			if value.Kind() != reflect.Slice {
+				panic("validate: dive on non-slice field " + name)
+			}
+			var errs []apperr.FieldError
+			for j := 0; j < value.Len(); j++ {
+				errs = append(errs, checkValue(fmt.Sprintf("%s[%d]", name, j), value.Index(j), rules[i+1:])...)
+			}
+			return errs
+		}
+		if err := checkRule(name, value, rule); err != nil {
+			return []apperr.FieldError{*err}
+		}
+	}
+	return nil
+}
+
+// checkRule applies a single rule to a value
+func checkRule(name string, value reflect.Value, rule string) *apperr.FieldError {
+	rule, param, _ := strings.Cut(rule, "=")
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	if rule == "required" {
+		if isEmpty(value) {
+			return &apperr.FieldError{Field: name, Rule: rule, Message: name + " is required"}
+		}
+		return nil
+	}
+	if isEmpty(value) {
+		return nil
+	}
+
+	switch rule {
+	case "max":
+		limit, err := strconv.Atoi(param)
+		if err != nil {
+			panic("validate: bad max on field " + name)
+		}
+		if value.Kind() == reflect.Slice {
+			if value.Len() > limit {
+				return &apperr.FieldError{Field: name, Rule: rule, Message: fmt.Sprintf("%s can list at most %d items", name, limit)}
+			}
+			return nil
+		}
+		if utf8.RuneCountInString(value.String()) > limit {
+			return &apperr.FieldError{Field: name, Rule: rule, Message: fmt.Sprintf("%s must be at most %d characters", name, limit)}
+		}
+	case "oneof":
+		options := strings.Fields(param)
+		for _, option := range options {
+			if value.String() == option {
+				return nil
+			}
+		}
+		return &apperr.FieldError{Field: name, Rule: rule, Message: fmt.Sprintf("%s must be one of %s", name, strings.Join(options, ", "))}
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q on field %s", rule, name))
+	}
+	return nil
+}
+
+// isEmpty reports whether a value counts as not set
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	default:
+		return value.IsZero()
+	}
+}
+
+// errNotObject is returned by UnknownFields for JSON that isn't an object
+var errNotObject = errors.New("expected a JSON object")
+
+// UnknownFields returns an error for each key of the JSON object in data that
+// doesn't match a field of the struct v points to or one of the allowed
+// extra names. It returns an error if data isn't a JSON object.
+func UnknownFields(data []byte, v interface{}, allowed ...string) ([]apperr.FieldError, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, err
+		}
+		return nil, errNotObject
+	}
+	if object == nil {
+		return nil, errNotObject
+	}
+
+	known := make(map[string]bool)
+	typ := reflect.Indirect(reflect.ValueOf(v)).Type()
+	for i := 0; i < typ.NumField(); i++ {
+		if name := jsonName(typ.Field(i)); name != "-" {
+			known[name] = true
+		}
+	}
+	for _, name := range allowed {
+		known[name] = true
+	}
+
+	var unknown []string
+	for key := range object {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+
+	errs := make([]apperr.FieldError, len(unknown))
+	for i, key := range unknown {
+		errs[i] = apperr.FieldError{Field: key, Rule: "unknown", Message: key + " is not a known field"}
+	}
+	return errs, nil
+}
+
+// jsonName returns the name a struct field has in JSON
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}